@@ -0,0 +1,285 @@
+// Copyright 2015 Yahoo Inc.
+// Licensed under the terms of the Apache version 2.0 license. See LICENSE file for terms.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/ardielle/ardielle-go/rdl"
+)
+
+type clientAPIGenerator struct {
+	registry        rdl.TypeRegistry
+	schema          *rdl.Schema
+	name            string
+	writer          *bufio.Writer
+	banner          string
+	precise         bool
+	ns              string
+	librdl          string
+	requestResponse bool
+}
+
+// clientAPIMethod is a method of the client interface. Record are the arguments recorded by the fake.
+type clientAPIMethod struct {
+	Name    string
+	Params  []string
+	Args    []string
+	Record  []string
+	Results []string
+}
+
+// Signature returns the signature of the method, with the names of its parameters.
+func (m *clientAPIMethod) Signature() string {
+	return m.Name + "(" + strings.Join(m.Params, ", ") + ") " + m.ResultSpec()
+}
+
+// FuncType returns the type of the stub function of the method.
+func (m *clientAPIMethod) FuncType() string {
+	return "func(" + strings.Join(m.Params, ", ") + ") " + m.ResultSpec()
+}
+
+// ResultSpec returns the result types of the method, parenthesized if there are several.
+func (m *clientAPIMethod) ResultSpec() string {
+	if len(m.Results) == 1 {
+		return m.Results[0]
+	}
+	return "(" + strings.Join(m.Results, ", ") + ")"
+}
+
+// ArgList returns the arguments of a call with the parameters of the method.
+func (m *clientAPIMethod) ArgList() string {
+	return strings.Join(m.Args, ", ")
+}
+
+// ZeroResults returns the variables for the results of the method other than the error, which
+// are returned as zero values when there is no stub function.
+func (m *clientAPIMethod) ZeroResults() []string {
+	var vars []string
+	for i, t := range m.Results[:len(m.Results)-1] {
+		vars = append(vars, fmt.Sprintf("r%d %s", i, t))
+	}
+	return vars
+}
+
+// ZeroReturn returns the return statement for the zero values of the results.
+func (m *clientAPIMethod) ZeroReturn() string {
+	var vals []string
+	for i := range m.Results[:len(m.Results)-1] {
+		vals = append(vals, fmt.Sprintf("r%d", i))
+	}
+	return "return " + strings.Join(append(vals, "nil"), ", ")
+}
+
+const clientAPITemplate = `{{header}}
+
+package {{package}}
+
+import (
+	"context"
+	"fmt"
+	rdl "{{rdlruntime}}"
+	"io"
+	"reflect"
+	"sync"
+)
+
+var _ = context.Background
+var _ = rdl.BaseTypeAny
+var _ = io.EOF
+
+//
+// {{api}} is the interface of the calls of {{client}}, so that the code that uses it can be
+// tested with the {{fake}} in its place.
+//
+type {{api}} interface {
+{{range methods}}	{{.Signature}}
+{{end}}}
+
+var _ {{api}} = {{client}}{}
+var _ {{api}} = (*{{fake}})(nil)
+
+//
+// {{fake}} is an implementation of {{api}} for tests. Each call is recorded, and then answered by
+// the stub function of its method, i.e. {{stub}}, or with zero values if it is nil.
+//
+type {{fake}} struct {
+{{range methods}}	{{.Name}}Func {{.FuncType}}
+{{end}}
+	mu    sync.Mutex
+	calls []{{fake}}Call
+}
+
+// {{fake}}Call is a call recorded by {{fake}}: the name of the method and its arguments, other
+// than the context.
+type {{fake}}Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// {{fake}}T is the part of testing.TB that the assertions of {{fake}} use.
+type {{fake}}T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+func (fake *{{fake}}) record(method string, args ...interface{}) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.calls = append(fake.calls, {{fake}}Call{Method: method, Args: args})
+}
+
+// Calls returns the calls of the method recorded so far, in order, or all the calls if method is empty.
+func (fake *{{fake}}) Calls(method string) []{{fake}}Call {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	var calls []{{fake}}Call
+	for _, call := range fake.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// CallCount returns the number of calls of the method recorded so far.
+func (fake *{{fake}}) CallCount(method string) int {
+	return len(fake.Calls(method))
+}
+
+// Reset forgets the calls recorded so far.
+func (fake *{{fake}}) Reset() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.calls = nil
+}
+
+// AssertCalled fails the test unless the method has been called with the arguments, as compared
+// by reflect.DeepEqual.
+func (fake *{{fake}}) AssertCalled(t {{fake}}T, method string, args ...interface{}) {
+	t.Helper()
+	calls := fake.Calls(method)
+	for _, call := range calls {
+		if reflect.DeepEqual(call.Args, args) {
+			return
+		}
+	}
+	t.Errorf("%s was not called with %s, calls: %s", method, fmt.Sprint(args...), fmt.Sprint(calls))
+}
+
+// AssertNotCalled fails the test if the method has been called.
+func (fake *{{fake}}) AssertNotCalled(t {{fake}}T, method string) {
+	t.Helper()
+	if calls := fake.Calls(method); len(calls) != 0 {
+		t.Errorf("%s was called %d times", method, len(calls))
+	}
+}
+{{range methods}}
+func (fake *{{fake}}) {{.Signature}} {
+	fake.record("{{.Name}}"{{range .Record}}, {{.}}{{end}})
+	if fake.{{.Name}}Func != nil {
+		return fake.{{.Name}}Func({{.ArgList}})
+	}
+{{range .ZeroResults}}	var {{.}}
+{{end}}	{{.ZeroReturn}}
+}
+{{end}}`
+
+// GenerateGoClientAPI generates the interface of the Go client, and a fake implementation of it
+// for tests, in <name>_api.go next to the client.
+func GenerateGoClientAPI(opts *generateOptions) error {
+	schema := opts.schema
+	outdir := opts.dirName
+	if outdir == "" {
+		outdir = "."
+	} else if strings.HasSuffix(outdir, ".go") {
+		outdir = filepath.Dir(outdir)
+	}
+	path := filepath.Join(outdir, strings.ToLower(string(schema.Name))+"_api.go")
+	out, file, _, err := outputWriter(path, "", ".go")
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		defer func() {
+			file.Close()
+			err := goFmt(path)
+			if err != nil {
+				fmt.Println("Warning: could not format go code:", err)
+			}
+		}()
+	}
+	gen := &clientAPIGenerator{
+		registry:        rdl.NewTypeRegistry(schema),
+		schema:          schema,
+		name:            capitalize(string(schema.Name)),
+		writer:          out,
+		banner:          opts.banner,
+		precise:         opts.preciseTypes,
+		ns:              opts.ns,
+		librdl:          opts.librdl,
+		requestResponse: opts.requestResponse,
+	}
+	funcMap := template.FuncMap{
+		"rdlruntime": func() string { return gen.librdl },
+		"header":     func() string { return generationHeader(gen.banner) },
+		"package":    func() string { return generationPackage(gen.schema, gen.ns) },
+		"client":     func() string { return gen.name + "Client" },
+		"api":        func() string { return gen.name + "API" },
+		"fake":       func() string { return "Fake" + gen.name },
+		"stub":       gen.stubExample,
+		"methods":    gen.methods,
+	}
+	t := template.Must(template.New("CLIENT_API_TEMPLATE").Funcs(funcMap).Parse(clientAPITemplate))
+	if err := t.Execute(out, schema); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func (gen *clientAPIGenerator) methods() []*clientAPIMethod {
+	var methods []*clientAPIMethod
+	for _, r := range gen.schema.Resources {
+		if gen.requestResponse {
+			m := (&reqRepClientGenerator{}).convertResource(gen.registry, r, gen.precise)
+			response := m.ResponseName
+			if m.Stream {
+				response = goEventsName(r)
+			}
+			methods = append(methods, &clientAPIMethod{
+				Name:    m.Name,
+				Params:  []string{"ctx context.Context", "req *" + m.RequestName},
+				Args:    []string{"ctx", "req"},
+				Record:  []string{"req"},
+				Results: []string{"*" + response, "error"},
+			})
+			continue
+		}
+		name, params := goMethodName(gen.registry, r, gen.precise)
+		var args []string
+		for _, p := range params {
+			args = append(args, strings.SplitN(p, " ", 2)[0])
+		}
+		methods = append(methods, &clientAPIMethod{
+			Name:    capitalize(name),
+			Params:  params,
+			Args:    args,
+			Record:  args,
+			Results: goMethodResults(gen.registry, r, gen.precise),
+		})
+	}
+	return methods
+}
+
+// stubExample returns the name of the stub function of the first method, for the doc comment.
+func (gen *clientAPIGenerator) stubExample() string {
+	if methods := gen.methods(); len(methods) > 0 {
+		return methods[0].Name + "Func"
+	}
+	return "GetThingFunc"
+}
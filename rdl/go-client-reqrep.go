@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"go/format"
+	"path/filepath"
 	"strings"
 	"text/template"
 
@@ -23,25 +24,40 @@ type reqRepClientGenerator struct {
 	precise     bool
 	ns          string
 	librdl      string
+	cache       bool
+	cassette    bool
 }
 
 const rrClientTemplate = `{{header}}
 
 package {{package}}
 
-import (
-	"bytes"
+import ({{if streams}}
+	"bufio"{{end}}
+	"bytes"{{if cache}}
+	"container/list"{{end}}
+	"context"{{if cache}}
+	"crypto/sha256"{{end}}
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	rdl "{{rdlruntime}}"
 	"io"
-	"io/ioutil"
+	"io/ioutil"{{if paginated}}
+	"iter"{{end}}
+	"log"
+	mathrand "math/rand"{{if forms}}
+	"mime/multipart"{{end}}
 	"net/http"
-	"net/url"
+	"net/url"{{if cassette}}
+	"os"{{end}}{{if forms}}
+	"sort"{{end}}
 	"strconv"
 	"strings"
-	"time"
-	"context"
+	"sync"
+	"time"{{if cassette}}
+	"unicode/utf8"{{end}}
 )
 
 var _ = json.Marshal
@@ -54,12 +70,33 @@ type {{client}} struct {
 	Transport   http.RoundTripper
 	CredsHeader *string
 	CredsToken  *string
+	Credentials CredentialsProvider
 	Timeout     time.Duration
+	SpanHook    ClientSpanHook
+	Retry       *RetryPolicy
+	Middleware  []ClientMiddleware
+	httpClient  *http.Client
 }
 
-// NewClient creates and returns a new HTTP client object for the {{.Name}} service
+// NewClient creates and returns a new HTTP client object for the {{.Name}} service. The client is
+// returned by value, as a copy of the one NewClientWithOptions creates: copies of it share its
+// http.Client, until the Transport or Timeout of a copy is changed, which then makes its calls
+// with a new http.Client each.
 func NewClient(url string, transport http.RoundTripper) {{client}} {
-	return {{client}}{url, transport, nil, nil, 0}
+	return *NewClientWithOptions(url, WithTransport(transport))
+}
+
+// NewClientWithOptions creates and returns a new HTTP client object for the {{.Name}} service,
+// configured by the options. The client makes all its calls with one http.Client.
+func NewClientWithOptions(url string, options ...ClientOption) *{{client}} {
+	client := &{{client}}{URL: url}
+	for _, option := range options {
+		option(client)
+	}
+	if client.httpClient == nil {
+		client.httpClient = &http.Client{Transport: client.Transport, Timeout: client.Timeout}
+	}
+	return client
 }
 
 // AddCredentials adds the credentials to the client for subsequent requests.
@@ -68,17 +105,23 @@ func (client *{{client}}) AddCredentials(header string, token string) {
 	client.CredsToken = &token
 }
 
+// AddBearerToken adds an "Authorization: Bearer" token, i.e. a JWT, to the client for subsequent requests.
+func (client *{{client}}) AddBearerToken(token string) {
+	client.AddCredentials("Authorization", "Bearer "+token)
+}
+
+// AddBasicCredentials adds HTTP Basic credentials to the client for subsequent requests.
+func (client *{{client}}) AddBasicCredentials(name string, password string) {
+	client.AddCredentials("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(name+":"+password)))
+}
+
+// getClient returns the http.Client of the client, or a new one if the client was not created by
+// a constructor, or its Transport or Timeout has been changed since.
 func (client {{client}}) getClient() *http.Client {
-	var c *http.Client
-	if client.Transport != nil {
-		c = &http.Client{Transport: client.Transport}
-	} else {
-		c = &http.Client{}
-	}
-	if client.Timeout > 0 {
-		c.Timeout = client.Timeout
+	if c := client.httpClient; c != nil && c.Timeout == client.Timeout && sameTransport(c.Transport, client.Transport) {
+		return c
 	}
-	return c
+	return &http.Client{Transport: client.Transport, Timeout: client.Timeout}
 }
 
 func (client {{client}}) addAuthHeader(req *http.Request) {
@@ -91,9 +134,16 @@ func (client {{client}}) addAuthHeader(req *http.Request) {
 	}
 }
 
-func (cl {{client}}) httpDo(ctx context.Context, req *http.Request) (*http.Response, error) {
-   client := cl.getClient()
-	resp, err := client.Do(req.WithContext(ctx))
+func (cl {{client}}) httpDo(ctx context.Context, resource string, req *http.Request) (*http.Response, error) {
+	client := cl.getClient()
+	resp, err := doWithRetries(ctx, cl.Retry, resource, req, func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return doWithCredentials(ctx, cl.Credentials, req, func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			ctx, span := cl.startSpan(ctx, resource, req)
+			resp, err := cl.roundTrip(client, resource, req.WithContext(ctx))
+			cl.endSpan(ctx, span, resp, err)
+			return resp, err
+		})
+	})
 	if err != nil {
 	   // get context error if there is one
 		select {
@@ -106,7 +156,7 @@ func (cl {{client}}) httpDo(ctx context.Context, req *http.Request) (*http.Respo
 }
 
 
-func (client {{client}}) httpGet(ctx context.Context, url string, headers map[string]string) (*http.Response, error) {
+func (client {{client}}) httpGet(ctx context.Context, resource string, url string, headers map[string]string) (*http.Response, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -117,10 +167,10 @@ func (client {{client}}) httpGet(ctx context.Context, url string, headers map[st
 			req.Header.Add(k, v)
 		}
 	}
-	return client.httpDo(ctx, req)
+	return client.httpDo(ctx, resource, req)
 }
 
-func (client {{client}}) httpDelete(ctx context.Context, url string, headers map[string]string) (*http.Response, error) {
+func (client {{client}}) httpDelete(ctx context.Context, resource string, url string, headers map[string]string) (*http.Response, error) {
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return nil, err
@@ -131,10 +181,10 @@ func (client {{client}}) httpDelete(ctx context.Context, url string, headers map
 			req.Header.Add(k, v)
 		}
 	}
-	return client.httpDo(ctx, req)
+	return client.httpDo(ctx, resource, req)
 }
 
-func (client {{client}}) httpPut(ctx context.Context, url string, headers map[string]string, body []byte) (*http.Response, error) {
+func (client {{client}}) httpPut(ctx context.Context, resource string, url string, headers map[string]string, body []byte) (*http.Response, error) {
 	var contentReader io.Reader
 	if body != nil {
 		contentReader = bytes.NewReader(body)
@@ -150,10 +200,10 @@ func (client {{client}}) httpPut(ctx context.Context, url string, headers map[st
 			req.Header.Add(k, v)
 		}
 	}
-   return client.httpDo(ctx, req)
+   return client.httpDo(ctx, resource, req)
 }
 
-func (client {{client}}) httpPost(ctx context.Context, url string, headers map[string]string, body []byte) (*http.Response, error) {
+func (client {{client}}) httpPost(ctx context.Context, resource string, url string, headers map[string]string, body []byte) (*http.Response, error) {
 	var contentReader io.Reader
 	if body != nil {
 		contentReader = bytes.NewReader(body)
@@ -169,10 +219,10 @@ func (client {{client}}) httpPost(ctx context.Context, url string, headers map[s
 			req.Header.Add(k, v)
 		}
 	}
-   return client.httpDo(ctx, req)
+   return client.httpDo(ctx, resource, req)
 }
 
-func (client {{client}}) httpPatch(ctx context.Context, url string, headers map[string]string, body []byte) (*http.Response, error) {
+func (client {{client}}) httpPatch(ctx context.Context, resource string, url string, headers map[string]string, body []byte) (*http.Response, error) {
 	var contentReader io.Reader
 	if body != nil {
 		contentReader = bytes.NewReader(body)
@@ -188,10 +238,10 @@ func (client {{client}}) httpPatch(ctx context.Context, url string, headers map[
 			req.Header.Add(k, v)
 		}
 	}
-   return client.httpDo(ctx, req)
+   return client.httpDo(ctx, resource, req)
 }
 
-func (client {{client}}) httpOptions(ctx context.Context, url string, headers map[string]string, body []byte) (*http.Response, error) {
+func (client {{client}}) httpOptions(ctx context.Context, resource string, url string, headers map[string]string, body []byte) (*http.Response, error) {
 	var contentReader io.Reader = nil
 	if body != nil {
 		contentReader = bytes.NewReader(body)
@@ -209,9 +259,36 @@ func (client {{client}}) httpOptions(ctx context.Context, url string, headers ma
 			req.Header.Add(k, v)
 		}
 	}
-   return client.httpDo(ctx, req)
+   return client.httpDo(ctx, resource, req)
+}
+
+func (client {{client}}) httpSend(ctx context.Context, resource string, method string, url string, headers map[string]string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-type", contentType)
+	client.addAuthHeader(req)
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+	return client.httpDo(ctx, resource, req)
+}
+
+func (client {{client}}) httpStream(ctx context.Context, resource string, url string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	client.addAuthHeader(req)
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+	return client.httpDo(ctx, resource, req)
 }
 
+` + clientOptionsTemplate + clientMiddlewareTemplate + clientCacheTemplate + clientCassetteTemplate + clientCredentialsTemplate + clientExceptionsTemplate + clientTracingTemplate + clientRetryTemplate + clientBodyTemplate + clientStreamTemplate + `
 func appendHeader(headers map[string]string, name, val string) map[string]string {
    if val == "" {
       return headers
@@ -302,17 +379,17 @@ func encodeParams(objs ...string) string {
 	}
 	return "?" + s[1:]
 }
-{{range methods}}
-type {{.RequestName}} struct {
-{{range .Inputs}}   {{.Name}} {{.TypeName}}
-{{end}}
-}
-
-type {{.ResponseName}} struct {
-{{range .Outputs}}   {{.Name}} {{.TypeName}}
-{{end}}
+{{range methods}}{{if .Stream}}
+func (client {{client}}) {{.Signature}} {
+	var headers map[string]string
+	{{range .Inputs}}{{if (ne .Header  "")}}
+	    headers = appendHeader(headers, "{{.Header}}", req.{{.Name}})
+   {{end}}{{end}}
+	url := client.URL + {{.URLExpression}}
+	resp, err := client.httpStream(ctx, "{{.Name}}", url, headers)
+{{.StreamOpen}}
 }
-
+{{else}}
 func (client {{client}}) {{.Signature}} {
 	var response {{.ResponseName}}
 	var headers map[string]string
@@ -329,10 +406,11 @@ func (client {{client}}) {{.Signature}} {
    {{.ResponseCases}}
    default:
       var errobj rdl.ResourceError
-	  err = json.NewDecoder(resp.Body).Decode(&errobj)
+	  outputBytes, err := ioutil.ReadAll(resp.Body)
 	  if err != nil {
 		  return nil, err
 	  }
+{{.ExceptionCases}}	  json.Unmarshal(outputBytes, &errobj)
 	   if errobj.Code == 0 {
 	      errobj.Code = resp.StatusCode
 	   }
@@ -345,8 +423,89 @@ func (client {{client}}) {{.Signature}} {
    return &response, nil
 	//end loop
 }
+{{paginate .Resource}}{{end}}{{end}}`
+
+const rrTypesTemplate = `{{header}}
+
+package {{package}}
+
+import (
+	"io"
+
+	rdl "{{rdlruntime}}"
+)
+
+var _ = rdl.BaseTypeAny
+var _ = io.EOF
+{{range methods}}
+// {{.RequestName}} holds the inputs of {{.Name}}.
+type {{.RequestName}} struct {
+{{range .Inputs}}   {{.Name}} {{.TypeName}}
+{{end}}
+}
+
+// {{.ResponseName}} holds the outputs of {{.Name}}.
+type {{.ResponseName}} struct {
+{{range .Outputs}}   {{.Name}} {{.TypeName}}
+{{end}}
+}
 {{end}}`
 
+// GenerateGoRequestResponseTypes generates the request and response structs shared by the
+// go-server and go-client generators in request/response mode, next to their output files.
+func GenerateGoRequestResponseTypes(opts *generateOptions) error {
+	schema := opts.schema
+	outdir := opts.dirName
+	if outdir == "" {
+		outdir = "."
+	} else if strings.HasSuffix(outdir, ".go") {
+		outdir = filepath.Dir(outdir)
+	}
+	path := filepath.Join(outdir, strings.ToLower(string(schema.Name))+"_reqrep.go")
+	out, file, _, err := outputWriter(path, "", ".go")
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		defer func() {
+			file.Close()
+			err := goFmt(path)
+			if err != nil {
+				fmt.Println("Warning: could not format go code:", err)
+			}
+		}()
+	}
+	gen := &reqRepClientGenerator{
+		registry: rdl.NewTypeRegistry(schema),
+		schema:   schema,
+		name:     capitalize(string(schema.Name)),
+		writer:   out,
+		banner:   opts.banner,
+		precise:  opts.preciseTypes,
+		ns:       opts.ns,
+		librdl:   opts.librdl,
+	}
+	funcMap := template.FuncMap{
+		"rdlruntime": func() string { return gen.librdl },
+		"header":     func() string { return generationHeader(gen.banner) },
+		"package":    func() string { return generationPackage(gen.schema, gen.ns) },
+		"methods":    gen.methods,
+	}
+	t := template.Must(template.New("REQREP_TYPES_TEMPLATE").Funcs(funcMap).Parse(rrTypesTemplate))
+	if err := t.Execute(out, schema); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func (gen *reqRepClientGenerator) methods() []*reqRepMethod {
+	output := make([]*reqRepMethod, 0, len(gen.schema.Resources))
+	for _, r := range gen.schema.Resources {
+		output = append(output, gen.convertResource(gen.registry, r, gen.precise))
+	}
+	return output
+}
+
 func (gen *reqRepClientGenerator) emitClient() error {
 	commentFun := func(s string) string {
 		return formatComment(s, 0, 80)
@@ -359,22 +518,25 @@ func (gen *reqRepClientGenerator) emitClient() error {
 		return s
 	}
 
-	methodFunc := func() []*reqRepMethod {
-		output := make([]*reqRepMethod, 0, len(gen.schema.Resources))
-		for _, r := range gen.schema.Resources {
-			output = append(output, gen.convertResource(gen.registry, r, gen.precise))
-		}
-		return output
-	}
-
 	funcMap := template.FuncMap{
-		"rdlruntime": func() string { return gen.librdl },
-		"header":     func() string { return generationHeader(gen.banner) },
-		"package":    func() string { return generationPackage(gen.schema, gen.ns) },
-		"basename":   basenameFunc,
-		"comment":    commentFun,
-		"methods":    methodFunc,
-		"client":     func() string { return gen.name + "Client" },
+		"rdlruntime":  func() string { return gen.librdl },
+		"header":      func() string { return generationHeader(gen.banner) },
+		"package":     func() string { return generationPackage(gen.schema, gen.ns) },
+		"basename":    basenameFunc,
+		"comment":     commentFun,
+		"methods":     gen.methods,
+		"client":      func() string { return gen.name + "Client" },
+		"streamTypes": func() []*goStreamType { return goStreamTypes(gen.registry, gen.schema, gen.precise) },
+		"exceptions":  func() []string { return goExceptionTypes(gen.registry, gen.schema) },
+		"resources":   func() []*goClientResource { return goClientResources(gen.registry, gen.schema, gen.precise) },
+		"version":     func() string { return goSchemaVersion(gen.schema) },
+		"idempotent":  func() []string { return goIdempotentResources(gen.registry, gen.schema, gen.precise) },
+		"paginated":   func() bool { return goPaginated(gen.registry, gen.schema, gen.precise) },
+		"paginate":    func(r *rdl.Resource) string { return goPaginationMethods(gen.registry, r, gen.precise, gen.name+"Client", true) },
+		"streams":     func() bool { return len(goStreamTypes(gen.registry, gen.schema, gen.precise)) > 0 },
+		"forms":       func() bool { return goFormBodies(gen.registry, gen.schema) },
+		"cache":       func() bool { return gen.cache },
+		"cassette":    func() bool { return gen.cassette },
 	}
 	t := template.Must(template.New("REQREP_CLIENT_TEMPLATE").Funcs(funcMap).Parse(rrClientTemplate))
 	var output bytes.Buffer
@@ -415,12 +577,23 @@ type reqRepMethod struct {
 	ResponseName    string
 	Inputs          []*reqRepVar
 	Outputs         []*reqRepVar
+	ContentType     string
+	FormKinds       string
+	Stream          bool
+	ExceptionCases  string
 }
 
 func (m *reqRepMethod) Signature() string {
+	if m.Stream {
+		return fmt.Sprintf("%s(ctx context.Context, req *%s) (*%s, error)", m.Name, m.RequestName, goEventsName(m.Resource))
+	}
 	return fmt.Sprintf("%s(ctx context.Context, req *%s) (*%s, error)", m.Name, m.RequestName, m.ResponseName)
 }
 
+func (m *reqRepMethod) StreamOpen() string {
+	return fmt.Sprintf(clientStreamOpenTemplate, goEventsName(m.Resource))
+}
+
 func (m *reqRepMethod) URLExpression() string {
 	// TODO: include Query Parameters
 	exprs := m.PathExpression[:]
@@ -445,24 +618,31 @@ func (m *reqRepMethod) Invocation() string {
 	var s string
 	switch method {
 	case "Get", "Delete":
-		s = "\tresp, err := client.http" + method + "(ctx, url, headers)\n"
+		s = "\tresp, err := client.http" + method + "(ctx, " + fmt.Sprintf("%q", m.Name) + ", url, headers)\n"
 	case "Put", "Post", "Patch":
 		bodyParam := findBodyParam()
+		sendArg := "ctx, " + fmt.Sprintf("%q, %q", m.Name, m.Method) + ", url, headers"
 		if bodyParam == "" {
 			s = "\tvar contentBytes []byte\n"
+		} else if m.ContentType == "application/octet-stream" {
+			return "\tresp, err := client.httpSend(" + sendArg + ", " + fmt.Sprintf("%q", m.ContentType) + ", " + bodyParam + ")\n"
+		} else if m.ContentType != "" {
+			s = "\tcontentType, contentBytes, err := encodeFormBody(" + bodyParam + ", " + m.FormKinds + ", " + fmt.Sprint(m.ContentType == "multipart/form-data") + ")\n"
+			s += "\tif err != nil {\n\t\treturn nil, err\n\t}\n"
+			return s + "\tresp, err := client.httpSend(" + sendArg + ", contentType, bytes.NewReader(contentBytes))\n"
 		} else {
 			s = "\tcontentBytes, err := json.Marshal(" + bodyParam + ")\n"
 			s += "\tif err != nil {\n\t\treturn nil, err\n\t}\n"
 		}
-		s += "\tresp, err := client.http" + method + "(ctx, url, headers, contentBytes)\n"
+		s += "\tresp, err := client.http" + method + "(ctx, " + fmt.Sprintf("%q", m.Name) + ", url, headers, contentBytes)\n"
 	case "Options":
 		bodyParam := findBodyParam()
 		if bodyParam != "" {
 			s = "\tcontentBytes, err := json.Marshal(" + bodyParam + ")\n"
 			s += "\tif err != nil {\n\t\treturn nil, err\n\t}\n"
-			s += "\tresp, err := client.http" + method + "(ctx, url, headers, contentBytes)\n"
+			s += "\tresp, err := client.http" + method + "(ctx, " + fmt.Sprintf("%q", m.Name) + ", url, headers, contentBytes)\n"
 		} else {
-			s = "\tresp, err := client.http" + method + "(ctx, url, headers, nil)\n"
+			s = "\tresp, err := client.http" + method + "(ctx, " + fmt.Sprintf("%q", m.Name) + ", url, headers, nil)\n"
 		}
 	}
 	return s
@@ -529,9 +709,9 @@ func (m *reqRepMethod) ParseOutputHeaders() (string, error) {
 	for _, out := range m.Outputs {
 		if out.Header != "" {
 			if out.TypeName != "string" {
-				code.Printf("response.%s = %s(resp.Header.Get(rdl.FoldHttpHeaderName(%q))", out.Name, out.TypeName, out.Header)
+				code.Printf("response.%s = %s(resp.Header.Get(rdl.FoldHttpHeaderName(%q)))", out.Name, out.TypeName, out.Header)
 			} else {
-				code.Printf("response.%s = resp.Header.Get(rdl.FoldHttpHeaderName(%q)", out.Name, out.Header)
+				code.Printf("response.%s = resp.Header.Get(rdl.FoldHttpHeaderName(%q))", out.Name, out.Header)
 			}
 		}
 	}
@@ -583,12 +763,17 @@ func (rr *reqRepClientGenerator) convertOutput(reg rdl.TypeRegistry, v *rdl.Reso
 
 func (rr *reqRepClientGenerator) convertResource(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) *reqRepMethod {
 	var method reqRepMethod
-	bodyType := string(gomodel.SafeTypeVarName(r.Type))
+	method.ContentType = goBodyContentType(reg, r, false)
 	for _, v := range r.Inputs {
 		if input := rr.convertInput(reg, v, precise); input != nil {
 			method.Inputs = append(method.Inputs, input)
 			if input.IsBody() {
-				bodyType = input.TypeName
+				switch method.ContentType {
+				case "application/octet-stream":
+					input.TypeName = "io.Reader"
+				case "multipart/form-data", "application/x-www-form-urlencoded":
+					method.FormKinds, _ = goFormFieldKinds(reg, v.Type)
+				}
 			}
 		}
 	}
@@ -600,23 +785,19 @@ func (rr *reqRepClientGenerator) convertResource(reg rdl.TypeRegistry, r *rdl.Re
 		})
 	}
 	for _, v := range r.Outputs {
-		if input := rr.convertOutput(reg, v, precise); input != nil {
-			method.Inputs = append(method.Inputs, input)
+		if output := rr.convertOutput(reg, v, precise); output != nil {
+			method.Outputs = append(method.Outputs, output)
 		}
 	}
 	method.Resource = r
-	method.Name = string(r.Name)
+	method.Stream = streamResource(r)
+	method.ExceptionCases = goExceptionCases(reg, r, "return nil, ", "outputBytes")
+	// the method and its request and response types are named as the methods of the other clients
+	// and servers, also for resources without a name
+	name, _ := goMethodName(reg, r, precise)
+	method.Name = capitalize(name)
 	method.Method = r.Method
 	method.Comment = r.Comment
-	if method.Name == "" {
-		methodTypeName := bodyType
-		if strings.HasPrefix(methodTypeName, "*") {
-			methodTypeName = methodTypeName[1:]
-		}
-		method.Name = capitalize(strings.ToLower(string(r.Method)) + methodTypeName)
-	} else {
-		method.Name = capitalize(method.Name)
-	}
 	method.RequestName = method.Name + "Request"
 	method.ResponseName = method.Name + "Response"
 	findPathVariable := func(name string) *reqRepVar {
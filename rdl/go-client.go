@@ -5,9 +5,12 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
@@ -26,6 +29,8 @@ type clientGenerator struct {
 	precise     bool
 	ns          string
 	librdl      string
+	cache       bool
+	cassette    bool
 }
 
 // GenerateGoClient generates the client code to talk to the server.
@@ -48,6 +53,9 @@ func GenerateGoClient(opts *generateOptions) error {
 	} else {
 		name = name + "_client.go"
 	}
+	if err := goCheckFormBodies(rdl.NewTypeRegistry(schema), schema); err != nil {
+		return err
+	}
 	filepath := outdir + "/" + name
 	out, file, _, err := outputWriter(filepath, "", ".go")
 	if err != nil {
@@ -62,7 +70,23 @@ func GenerateGoClient(opts *generateOptions) error {
 			}
 		}()
 	}
+	if err := GenerateGoTraceContext(opts); err != nil {
+		return err
+	}
+	if opts.clientFake {
+		if err := GenerateGoClientAPI(opts); err != nil {
+			return err
+		}
+	}
+	if opts.inProcessClient {
+		if err := GenerateGoInProcessClient(opts); err != nil {
+			return err
+		}
+	}
 	if opts.requestResponse {
+		if err := GenerateGoRequestResponseTypes(opts); err != nil {
+			return err
+		}
 		gen := &reqRepClientGenerator{
 			registry:    rdl.NewTypeRegistry(schema),
 			schema:      schema,
@@ -73,6 +97,8 @@ func GenerateGoClient(opts *generateOptions) error {
 			precise:     precise,
 			ns:          ns,
 			librdl:      librdl,
+			cache:       opts.clientCache,
+			cassette:    opts.clientCassette,
 		}
 		if err := gen.emitClient(); err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: generating client code: %v\n", err)
@@ -90,6 +116,8 @@ func GenerateGoClient(opts *generateOptions) error {
 			precise:     precise,
 			ns:          ns,
 			librdl:      librdl,
+			cache:       opts.clientCache,
+			cassette:    opts.clientCassette,
 		}
 		gen.emitClient()
 		out.Flush()
@@ -101,18 +129,32 @@ const clientTemplate = `{{header}}
 
 package {{package}}
 
-import (
-	"bytes"
+import ({{if streams}}
+	"bufio"{{end}}
+	"bytes"{{if cache}}
+	"container/list"{{end}}
+	"context"{{if cache}}
+	"crypto/sha256"{{end}}
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	rdl "{{rdlruntime}}"
 	"io"
-	"io/ioutil"
+	"io/ioutil"{{if paginated}}
+	"iter"{{end}}
+	"log"
+	mathrand "math/rand"{{if forms}}
+	"mime/multipart"{{end}}
 	"net/http"
-	"net/url"
+	"net/url"{{if cassette}}
+	"os"{{end}}{{if forms}}
+	"sort"{{end}}
 	"strconv"
 	"strings"
-	"time"
+	"sync"
+	"time"{{if cassette}}
+	"unicode/utf8"{{end}}
 )
 
 var _ = json.Marshal
@@ -125,12 +167,41 @@ type {{client}} struct {
 	Transport   http.RoundTripper
 	CredsHeader *string
 	CredsToken  *string
+	Credentials CredentialsProvider
 	Timeout     time.Duration
+	SpanHook    ClientSpanHook
+	Retry       *RetryPolicy
+	Middleware  []ClientMiddleware
+	httpClient  *http.Client
+	ctx         context.Context
 }
 
-// NewClient creates and returns a new HTTP client object for the {{.Name}} service
+// NewClient creates and returns a new HTTP client object for the {{.Name}} service. The client is
+// returned by value, as a copy of the one NewClientWithOptions creates: copies of it share its
+// http.Client, until the Transport or Timeout of a copy is changed, which then makes its calls
+// with a new http.Client each.
 func NewClient(url string, transport http.RoundTripper) {{client}} {
-	return {{client}}{url, transport, nil, nil, 0}
+	return *NewClientWithOptions(url, WithTransport(transport))
+}
+
+// NewClientWithOptions creates and returns a new HTTP client object for the {{.Name}} service,
+// configured by the options. The client makes all its calls with one http.Client.
+func NewClientWithOptions(url string, options ...ClientOption) *{{client}} {
+	client := &{{client}}{URL: url}
+	for _, option := range options {
+		option(client)
+	}
+	if client.httpClient == nil {
+		client.httpClient = &http.Client{Transport: client.Transport, Timeout: client.Timeout}
+	}
+	return client
+}
+
+// WithContext returns a copy of the client that makes its requests with the specified context,
+// which is used for cancellation and carries the trace context to propagate.
+func (client {{client}}) WithContext(ctx context.Context) {{client}} {
+	client.ctx = ctx
+	return client
 }
 
 // AddCredentials adds the credentials to the client for subsequent requests.
@@ -139,17 +210,23 @@ func (client *{{client}}) AddCredentials(header string, token string) {
 	client.CredsToken = &token
 }
 
+// AddBearerToken adds an "Authorization: Bearer" token, i.e. a JWT, to the client for subsequent requests.
+func (client *{{client}}) AddBearerToken(token string) {
+	client.AddCredentials("Authorization", "Bearer "+token)
+}
+
+// AddBasicCredentials adds HTTP Basic credentials to the client for subsequent requests.
+func (client *{{client}}) AddBasicCredentials(name string, password string) {
+	client.AddCredentials("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(name+":"+password)))
+}
+
+// getClient returns the http.Client of the client, or a new one if the client was not created by
+// a constructor, or its Transport or Timeout has been changed since.
 func (client {{client}}) getClient() *http.Client {
-	var c *http.Client
-	if client.Transport != nil {
-		c = &http.Client{Transport: client.Transport}
-	} else {
-		c = &http.Client{}
-	}
-	if client.Timeout > 0 {
-		c.Timeout = client.Timeout
+	if c := client.httpClient; c != nil && c.Timeout == client.Timeout && sameTransport(c.Transport, client.Transport) {
+		return c
 	}
-	return c
+	return &http.Client{Transport: client.Transport, Timeout: client.Timeout}
 }
 
 func (client {{client}}) addAuthHeader(req *http.Request) {
@@ -162,8 +239,22 @@ func (client {{client}}) addAuthHeader(req *http.Request) {
 	}
 }
 
-func (client {{client}}) httpGet(url string, headers map[string]string) (*http.Response, error) {
-	hclient := client.getClient()
+func (client {{client}}) httpDo(resource string, req *http.Request) (*http.Response, error) {
+	ctx := client.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return doWithRetries(ctx, client.Retry, resource, req, func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return doWithCredentials(ctx, client.Credentials, req, func(ctx context.Context, req *http.Request) (*http.Response, error) {
+			ctx, span := client.startSpan(ctx, resource, req)
+			resp, err := client.roundTrip(client.getClient(), resource, req.WithContext(ctx))
+			client.endSpan(ctx, span, resp, err)
+			return resp, err
+		})
+	})
+}
+
+func (client {{client}}) httpGet(resource string, url string, headers map[string]string) (*http.Response, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -174,11 +265,10 @@ func (client {{client}}) httpGet(url string, headers map[string]string) (*http.R
 			req.Header.Add(k, v)
 		}
 	}
-	return hclient.Do(req)
+	return client.httpDo(resource, req)
 }
 
-func (client {{client}}) httpDelete(url string, headers map[string]string) (*http.Response, error) {
-	hclient := client.getClient()
+func (client {{client}}) httpDelete(resource string, url string, headers map[string]string) (*http.Response, error) {
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return nil, err
@@ -189,15 +279,14 @@ func (client {{client}}) httpDelete(url string, headers map[string]string) (*htt
 			req.Header.Add(k, v)
 		}
 	}
-	return hclient.Do(req)
+	return client.httpDo(resource, req)
 }
 
-func (client {{client}}) httpPut(url string, headers map[string]string, body []byte) (*http.Response, error) {
+func (client {{client}}) httpPut(resource string, url string, headers map[string]string, body []byte) (*http.Response, error) {
 	var contentReader io.Reader
 	if body != nil {
 		contentReader = bytes.NewReader(body)
 	}
-	hclient := client.getClient()
 	req, err := http.NewRequest("PUT", url, contentReader)
 	if err != nil {
 		return nil, err
@@ -209,15 +298,14 @@ func (client {{client}}) httpPut(url string, headers map[string]string, body []b
 			req.Header.Add(k, v)
 		}
 	}
-	return hclient.Do(req)
+	return client.httpDo(resource, req)
 }
 
-func (client {{client}}) httpPost(url string, headers map[string]string, body []byte) (*http.Response, error) {
+func (client {{client}}) httpPost(resource string, url string, headers map[string]string, body []byte) (*http.Response, error) {
 	var contentReader io.Reader
 	if body != nil {
 		contentReader = bytes.NewReader(body)
 	}
-	hclient := client.getClient()
 	req, err := http.NewRequest("POST", url, contentReader)
 	if err != nil {
 		return nil, err
@@ -229,15 +317,14 @@ func (client {{client}}) httpPost(url string, headers map[string]string, body []
 			req.Header.Add(k, v)
 		}
 	}
-	return hclient.Do(req)
+	return client.httpDo(resource, req)
 }
 
-func (client {{client}}) httpPatch(url string, headers map[string]string, body []byte) (*http.Response, error) {
+func (client {{client}}) httpPatch(resource string, url string, headers map[string]string, body []byte) (*http.Response, error) {
 	var contentReader io.Reader
 	if body != nil {
 		contentReader = bytes.NewReader(body)
 	}
-	hclient := client.getClient()
 	req, err := http.NewRequest("PATCH", url, contentReader)
 	if err != nil {
 		return nil, err
@@ -249,15 +336,14 @@ func (client {{client}}) httpPatch(url string, headers map[string]string, body [
 			req.Header.Add(k, v)
 		}
 	}
-	return hclient.Do(req)
+	return client.httpDo(resource, req)
 }
 
-func (client {{client}}) httpOptions(url string, headers map[string]string, body []byte) (*http.Response, error) {
+func (client {{client}}) httpOptions(resource string, url string, headers map[string]string, body []byte) (*http.Response, error) {
 	var contentReader io.Reader = nil
 	if body != nil {
 		contentReader = bytes.NewReader(body)
 	}
-	hclient := client.getClient()
 	req, err := http.NewRequest("OPTIONS", url, contentReader)
 	if err != nil {
 		return nil, err
@@ -271,9 +357,36 @@ func (client {{client}}) httpOptions(url string, headers map[string]string, body
 			req.Header.Add(k, v)
 		}
 	}
-	return hclient.Do(req)
+	return client.httpDo(resource, req)
+}
+
+func (client {{client}}) httpSend(resource string, method string, url string, headers map[string]string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-type", contentType)
+	client.addAuthHeader(req)
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+	return client.httpDo(resource, req)
+}
+
+func (client {{client}}) httpStream(resource string, url string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	client.addAuthHeader(req)
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+	return client.httpDo(resource, req)
 }
 
+` + clientOptionsTemplate + clientMiddlewareTemplate + clientCacheTemplate + clientCassetteTemplate + clientCredentialsTemplate + clientExceptionsTemplate + clientTracingTemplate + clientRetryTemplate + clientBodyTemplate + clientStreamTemplate + `
 func encodeStringParam(name string, val string, def string) string {
 	if val == def {
 		return ""
@@ -381,7 +494,7 @@ func encodeParams(objs ...string) string {
 func (client {{client}}) {{method_sig .}} {
 {{method_body .}}
 }
-{{end}}`
+{{paginate .}}{{end}}`
 
 func (gen *clientGenerator) emitClient() error {
 	commentFun := func(s string) string {
@@ -421,30 +534,48 @@ func (gen *clientGenerator) emitClient() error {
 		"method_sig":  func(r *rdl.Resource) string { return goMethodSignature(gen.registry, r, gen.precise) },
 		"method_body": func(r *rdl.Resource) string { return goMethodBody(gen.registry, r, gen.precise) },
 		"client":      func() string { return gen.name + "Client" },
+		"streamTypes": func() []*goStreamType { return goStreamTypes(gen.registry, gen.schema, gen.precise) },
+		"exceptions":  func() []string { return goExceptionTypes(gen.registry, gen.schema) },
+		"resources":   func() []*goClientResource { return goClientResources(gen.registry, gen.schema, gen.precise) },
+		"version":     func() string { return goSchemaVersion(gen.schema) },
+		"idempotent":  func() []string { return goIdempotentResources(gen.registry, gen.schema, gen.precise) },
+		"paginated":   func() bool { return goPaginated(gen.registry, gen.schema, gen.precise) },
+		"paginate":    func(r *rdl.Resource) string { return goPaginationMethods(gen.registry, r, gen.precise, gen.name+"Client", false) },
+		"streams":     func() bool { return len(goStreamTypes(gen.registry, gen.schema, gen.precise)) > 0 },
+		"forms":       func() bool { return goFormBodies(gen.registry, gen.schema) },
+		"cache":       func() bool { return gen.cache },
+		"cassette":    func() bool { return gen.cassette },
 	}
 	t := template.Must(template.New("FOO").Funcs(funcMap).Parse(clientTemplate))
 	return t.Execute(gen.writer, gen.schema)
 }
 
 func goMethodSignature(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
-	noContent := r.Expected == "NO_CONTENT" && r.Alternatives == nil
-	returnSpec := "error"
-	//fixme: no content *with* output headers
-	if !noContent {
-		gtype := gomodel.GoType(reg, r.Type, false, "", "", precise, true)
-		returnSpec = "(" + gtype
-		if r.Outputs != nil {
-			for _, o := range r.Outputs {
-				otype := gomodel.GoType(reg, o.Type, false, "", "", precise, true)
-				returnSpec += ", " + otype
-			}
-		}
-		returnSpec += ", error)"
+	results := goMethodResults(reg, r, precise)
+	returnSpec := results[0]
+	if len(results) > 1 {
+		returnSpec = "(" + strings.Join(results, ", ") + ")"
 	}
 	methName, params := goMethodName(reg, r, precise)
 	return capitalize(methName) + "(" + strings.Join(params, ", ") + ") " + returnSpec
 }
 
+// goMethodResults returns the result types of the client method of a resource, the last one error.
+func goMethodResults(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) []string {
+	if streamResource(r) {
+		return []string{"*" + goEventsName(r), "error"}
+	}
+	//fixme: no content *with* output headers
+	if r.Expected == "NO_CONTENT" && r.Alternatives == nil {
+		return []string{"error"}
+	}
+	results := []string{gomodel.GoType(reg, r.Type, false, "", "", precise, true)}
+	for _, o := range r.Outputs {
+		results = append(results, gomodel.GoType(reg, o.Type, false, "", "", precise, true))
+	}
+	return append(results, "error")
+}
+
 func goLiteral(lit interface{}, baseType string) string {
 	if lit == nil {
 		if baseType == "Bool" {
@@ -537,6 +668,9 @@ func goMethodBody(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
 		dataReturn = "return nil"
 		dataDef = ""
 	}
+	if streamResource(r) {
+		dataDef = ""
+	}
 	if r.Outputs != nil {
 		dret := "return data"
 		eret := "return nil"
@@ -559,10 +693,13 @@ func goMethodBody(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
 	if dataDef != "" {
 		s += "\t" + dataDef + "\n"
 	}
-	httpArg := "url, nil"
+	methName, _ := goMethodName(reg, r, precise)
+	httpArg := fmt.Sprintf("%q, url, nil", capitalize(methName))
+	headersArg := "nil"
 	if len(headers) > 0 {
 		//not optimal: when the headers are empty ("") they are still included
-		httpArg = "url, headers"
+		httpArg = fmt.Sprintf("%q, url, headers", capitalize(methName))
+		headersArg = "headers"
 		s += "\theaders := map[string]string{\n"
 		for k, v := range headers {
 			s += fmt.Sprintf("\t\t%q: %s,\n", k, v)
@@ -571,6 +708,10 @@ func goMethodBody(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
 	}
 	url := explodeURL(reg, r)
 	s += "\turl := client.URL + " + url + "\n"
+	if streamResource(r) {
+		s += "\tresp, err := client.httpStream(" + httpArg + ")\n"
+		return s + fmt.Sprintf(clientStreamOpenTemplate, goEventsName(r))
+	}
 	method := capitalize(strings.ToLower(r.Method))
 	assign := ":="
 	switch method {
@@ -585,8 +726,20 @@ func goMethodBody(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
 				break
 			}
 		}
+		contentType := goBodyContentType(reg, r, true)
+		sendArg := fmt.Sprintf("%q, %q, url, %s", capitalize(methName), r.Method, headersArg)
 		if bodyParam == "" {
 			s += "\tvar contentBytes []byte\n"
+		} else if contentType == "application/octet-stream" {
+			s += "\tresp, err := client.httpSend(" + sendArg + ", " + fmt.Sprintf("%q", contentType) + ", " + bodyParam + ")\n"
+			break
+		} else if contentType != "" {
+			kinds, _ := goFormFieldKinds(reg, bodyType(r))
+			s += "\tcontentType, contentBytes, err := encodeFormBody(" + bodyParam + ", " + kinds + ", " + fmt.Sprint(contentType == "multipart/form-data") + ")\n"
+			s += "\tif err != nil {\n\t\t" + errorReturn + "\n\t}\n"
+			s += "\tresp, err := client.httpSend(" + sendArg + ", contentType, bytes.NewReader(contentBytes))\n"
+			assign = "="
+			break
 		} else {
 			s += "\tcontentBytes, err := json.Marshal(" + bodyParam + ")\n"
 			s += "\tif err != nil {\n\t\t" + errorReturn + "\n\t}\n"
@@ -667,6 +820,7 @@ func goMethodBody(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
 	s += "\t\tvar errobj rdl.ResourceError\n"
 	s += "\t\tcontentBytes, err " + assign + " ioutil.ReadAll(resp.Body)\n"
 	s += "\t\tif err != nil {\n\t\t\t" + errorReturn + "\n\t\t}\n"
+	s += goExceptionCases(reg, r, strings.TrimSuffix(errorReturn, "err"), "contentBytes")
 	s += "\t\tjson.Unmarshal(contentBytes, &errobj)\n"
 	s += "\t\tif errobj.Code == 0 {\n"
 	s += "\t\t\terrobj.Code = resp.StatusCode\n"
@@ -679,3 +833,1788 @@ func goMethodBody(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
 
 	return s
 }
+
+// goExceptionTypes returns the struct types declared as exceptions of the resources of a schema,
+// which the clients return wrapped in typed errors. Exceptions of other types, i.e. ResourceError,
+// are returned as an rdl.ResourceError.
+func goExceptionTypes(reg rdl.TypeRegistry, schema *rdl.Schema) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, r := range schema.Resources {
+		for _, e := range r.Exceptions {
+			t := reg.FindType(rdl.TypeRef(e.Type))
+			if e.Type != "ResourceError" && t != nil && reg.BaseType(t) == rdl.BaseTypeStruct && !seen[e.Type] {
+				seen[e.Type] = true
+				names = append(names, e.Type)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// goExceptionCases returns the code that decodes the body of an error response with a status
+// declared by the resource into its exception type, and returns it as a typed error. The return
+// statements start with ret, i.e. "return nil, ".
+func goExceptionCases(reg rdl.TypeRegistry, r *rdl.Resource, ret string, body string) string {
+	s := ""
+	for _, code := range sortedExceptionKeys(r.Exceptions) {
+		etype := r.Exceptions[code].Type
+		if t := reg.FindType(rdl.TypeRef(etype)); etype == "ResourceError" || t == nil || reg.BaseType(t) != rdl.BaseTypeStruct {
+			continue
+		}
+		s += "\t\tcase " + rdl.StatusCode(code) + ":\n"
+		s += "\t\t\tvar exception " + etype + "\n"
+		s += "\t\t\tif json.Unmarshal(" + body + ", &exception) == nil {\n"
+		s += "\t\t\t\t" + ret + "&" + etype + "Exception{Status: resp.StatusCode, Header: resp.Header, Body: &exception}\n"
+		s += "\t\t\t}\n"
+	}
+	if s == "" {
+		return ""
+	}
+	return "\t\tswitch resp.StatusCode {\n" + s + "\t\t}\n"
+}
+
+// goIdempotentResources returns the names of the resources annotated with x_idempotent, whose
+// calls the clients may retry whatever their method.
+func goIdempotentResources(reg rdl.TypeRegistry, schema *rdl.Schema, precise bool) []string {
+	var names []string
+	for _, r := range schema.Resources {
+		if _, ok := r.Annotations["x_idempotent"]; ok {
+			n, _ := goMethodName(reg, r, precise)
+			names = append(names, capitalize(n))
+		}
+	}
+	return names
+}
+
+// goClientResource describes a resource to the client middleware.
+type goClientResource struct {
+	Name    string
+	Method  string
+	Path    string
+	Headers string
+	Result  string
+}
+
+// goClientResources returns the resources of a schema, with the names the clients call them by,
+// their paths without the query, the quoted names of their header inputs, and the type that their
+// JSON responses are decoded into, if any.
+func goClientResources(reg rdl.TypeRegistry, schema *rdl.Schema, precise bool) []*goClientResource {
+	var resources []*goClientResource
+	for _, r := range schema.Resources {
+		n, _ := goMethodName(reg, r, precise)
+		path := strings.SplitN(r.Path, "?", 2)[0]
+		var headers []string
+		for _, in := range r.Inputs {
+			if in.Header != "" {
+				headers = append(headers, fmt.Sprintf("%q", in.Header))
+			}
+		}
+		result := ""
+		if !streamResource(r) && !(r.Expected == "NO_CONTENT" && r.Alternatives == nil) {
+			result = strings.TrimPrefix(gomodel.GoType(reg, r.Type, false, "", "", precise, true), "*")
+		}
+		resources = append(resources, &goClientResource{Name: capitalize(n), Method: strings.ToUpper(r.Method), Path: path, Headers: strings.Join(headers, ", "), Result: result})
+	}
+	return resources
+}
+
+// goSchemaVersion returns the version of the schema, or "0" if it has none.
+func goSchemaVersion(schema *rdl.Schema) string {
+	if schema.Version == nil {
+		return "0"
+	}
+	return fmt.Sprint(*schema.Version)
+}
+
+// goPagination describes the pages of a resource annotated with x_paginate, for its iterators.
+type goPagination struct {
+	Client     string
+	Method     string
+	Items      string
+	ItemType   string
+	Next       string
+	Token      string
+	TokenField string
+	TokenType  string
+	Empty      string
+	Params     string
+	Args       string
+	CallArgs   string
+	Results    string
+	Request    bool
+}
+
+//
+// goResourcePagination returns the pagination of a GET resource annotated with x_paginate, or nil.
+// The annotation has comma separated items, next and token settings, i.e.
+// "items=items,next=next,token=skip": the array field of the response type with the items of a
+// page, the field with the token of the next page, and the query parameter to pass it in. They
+// default to the only array field, "next", and "skip".
+//
+func goResourcePagination(reg rdl.TypeRegistry, r *rdl.Resource, precise bool, warn bool) *goPagination {
+	spec, ok := r.Annotations["x_paginate"]
+	if !ok {
+		return nil
+	}
+	fail := func(format string, args ...interface{}) *goPagination {
+		if warn {
+			log.Printf("RDL error: bad x_paginate on %s %s: %s\n", r.Method, r.Path, fmt.Sprintf(format, args...))
+		}
+		return nil
+	}
+	settings := map[string]string{"next": "next", "token": "skip"}
+	for _, setting := range strings.Split(spec, ",") {
+		if setting = strings.TrimSpace(setting); setting == "" {
+			continue
+		}
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 || (kv[0] != "items" && kv[0] != "next" && kv[0] != "token") {
+			return fail("unknown setting '%s'", setting)
+		}
+		settings[kv[0]] = strings.TrimSpace(kv[1])
+	}
+	t := reg.FindType(r.Type)
+	if r.Method != "GET" || streamResource(r) || t == nil || reg.BaseType(t) != rdl.BaseTypeStruct {
+		return fail("only GET resources of struct types are paginated")
+	}
+	var items, next *rdl.StructFieldDef
+	for _, f := range flattenedFields(reg, t) {
+		ft := reg.FindType(f.Type)
+		if ft != nil && reg.BaseType(ft) == rdl.BaseTypeArray && (settings["items"] == "" || settings["items"] == string(f.Name)) {
+			if items != nil {
+				return fail("the items field must be set, i.e. items=%s", f.Name)
+			}
+			items = f
+		}
+		if string(f.Name) == settings["next"] {
+			next = f
+		}
+	}
+	if items == nil || next == nil {
+		return fail("no items or %s field in %s", settings["next"], r.Type)
+	}
+	page := &goPagination{
+		Items: capitalize(string(items.Name)),
+		Next:  capitalize(string(next.Name)),
+	}
+	itemsType := gomodel.GoType(reg, items.Type, false, items.Items, items.Keys, precise, true)
+	page.ItemType = strings.TrimPrefix(itemsType, "[]")
+	nextType := gomodel.GoType(reg, next.Type, next.Optional, next.Items, next.Keys, precise, true)
+	methName, params := goMethodName(reg, r, precise)
+	page.Method = capitalize(methName)
+	var callArgs, iterParams, iterArgs []string
+	for i, in := range r.Inputs {
+		name := strings.SplitN(params[i], " ", 2)[0]
+		if in.QueryParam == settings["token"] {
+			page.Token = name
+			page.TokenField = capitalize(name)
+			page.TokenType = strings.SplitN(params[i], " ", 2)[1]
+		} else {
+			iterParams = append(iterParams, params[i])
+			iterArgs = append(iterArgs, name)
+		}
+		callArgs = append(callArgs, name)
+	}
+	if page.Token == "" {
+		return fail("no %s query parameter", settings["token"])
+	}
+	if page.TokenType != nextType {
+		return fail("the %s field is a %s, but the %s parameter is a %s", next.Name, nextType, settings["token"], page.TokenType)
+	}
+	switch {
+	case strings.HasPrefix(nextType, "*"):
+		page.Empty = "nil"
+	case reg.BaseType(reg.FindType(next.Type)) == rdl.BaseTypeString:
+		page.Empty = "\"\""
+	default:
+		page.Empty = "0"
+	}
+	page.Params = strings.Join(append([]string{"maxItems int"}, iterParams...), ", ")
+	page.Args = strings.Join(append([]string{"maxItems"}, iterArgs...), ", ")
+	page.CallArgs = strings.Join(callArgs, ", ")
+	page.Results = "page, err"
+	if n := len(goMethodResults(reg, r, precise)); n > 2 {
+		page.Results = "page" + strings.Repeat(", _", n-2) + ", err"
+	}
+	return page
+}
+
+// goPaginated returns true if a resource of the schema has iterators.
+func goPaginated(reg rdl.TypeRegistry, schema *rdl.Schema, precise bool) bool {
+	for _, r := range schema.Resources {
+		if goResourcePagination(reg, r, precise, false) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// goPaginationMethods returns the iterator methods of the client for a resource annotated with
+// x_paginate, or nothing.
+func goPaginationMethods(reg rdl.TypeRegistry, r *rdl.Resource, precise bool, client string, requestResponse bool) string {
+	page := goResourcePagination(reg, r, precise, true)
+	if page == nil {
+		return ""
+	}
+	page.Client, page.Request = client, requestResponse
+	var b bytes.Buffer
+	t := template.Must(template.New("PAGINATION_TEMPLATE").Parse(clientPaginationTemplate))
+	if err := t.Execute(&b, page); err != nil {
+		log.Printf("RDL error: cannot generate the iterators of %s: %v\n", page.Method, err)
+		return ""
+	}
+	return b.String()
+}
+
+// clientPaginationTemplate has the iterators of a paginated resource, in both client flavors.
+const clientPaginationTemplate = `{{if .Request}}
+//
+// {{.Method}}All returns an iterator over the {{.Items}} of the pages of {{.Method}}, from the one
+// of req on. Each page is fetched when the iteration reaches it, with the {{.Next}} of the previous
+// one as {{.TokenField}}. The iteration ends after the last page, after maxItems items unless it is
+// 0, when ctx is done, or with the first error.
+//
+func (client {{.Client}}) {{.Method}}All(ctx context.Context, maxItems int, req *{{.Method}}Request) iter.Seq2[{{.ItemType}}, error] {
+	return func(yield func({{.ItemType}}, error) bool) {
+		pageReq := *req
+		returned := 0
+		for {
+			var zero {{.ItemType}}
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			resp, err := client.{{.Method}}(ctx, &pageReq)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			page := resp.Body
+			for _, value := range page.{{.Items}} {
+				if maxItems > 0 && returned >= maxItems {
+					return
+				}
+				returned++
+				if !yield(value, nil) {
+					return
+				}
+			}
+			if page.{{.Next}} == {{.Empty}} || page.{{.Next}} == pageReq.{{.TokenField}} || (maxItems > 0 && returned >= maxItems) {
+				return
+			}
+			pageReq.{{.TokenField}} = page.{{.Next}}
+		}
+	}
+}
+
+// {{.Method}}Each calls f with each of the {{.Items}} of {{.Method}}All, and returns the first
+// error of a call or of f.
+func (client {{.Client}}) {{.Method}}Each(ctx context.Context, maxItems int, req *{{.Method}}Request, f func({{.ItemType}}) error) error {
+	for value, err := range client.{{.Method}}All(ctx, maxItems, req) {
+		if err != nil {
+			return err
+		}
+		if err := f(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+{{else}}
+//
+// {{.Method}}All returns an iterator over the {{.Items}} of the pages of {{.Method}}, from the first
+// one on. Each page is fetched when the iteration reaches it, with the {{.Next}} of the previous one
+// as {{.Token}}. The iteration ends after the last page, after maxItems items unless it is 0, when
+// the context of the client is done, or with the first error.
+//
+func (client {{.Client}}) {{.Method}}All({{.Params}}) iter.Seq2[{{.ItemType}}, error] {
+	return func(yield func({{.ItemType}}, error) bool) {
+		ctx := client.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		var {{.Token}} {{.TokenType}}
+		returned := 0
+		for {
+			var zero {{.ItemType}}
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			{{.Results}} := client.{{.Method}}({{.CallArgs}})
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, value := range page.{{.Items}} {
+				if maxItems > 0 && returned >= maxItems {
+					return
+				}
+				returned++
+				if !yield(value, nil) {
+					return
+				}
+			}
+			if page.{{.Next}} == {{.Empty}} || page.{{.Next}} == {{.Token}} || (maxItems > 0 && returned >= maxItems) {
+				return
+			}
+			{{.Token}} = page.{{.Next}}
+		}
+	}
+}
+
+// {{.Method}}Each calls f with each of the {{.Items}} of {{.Method}}All, and returns the first
+// error of a call or of f.
+func (client {{.Client}}) {{.Method}}Each({{.Params}}, f func({{.ItemType}}) error) error {
+	for value, err := range client.{{.Method}}All({{.Args}}) {
+		if err != nil {
+			return err
+		}
+		if err := f(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+{{end}}`
+
+// goStreamType is an event type of the stream resources of a schema.
+type goStreamType struct {
+	Name   string
+	GoType string
+}
+
+func goStreamTypes(reg rdl.TypeRegistry, schema *rdl.Schema, precise bool) []*goStreamType {
+	var types []*goStreamType
+	seen := make(map[string]bool)
+	for _, r := range schema.Resources {
+		if streamResource(r) && !seen[goEventsName(r)] {
+			seen[goEventsName(r)] = true
+			types = append(types, &goStreamType{Name: goEventsName(r), GoType: gomodel.GoType(reg, r.Type, false, "", "", precise, true)})
+		}
+	}
+	return types
+}
+
+// goEventsName returns the name of the generated reader of the events of a stream resource.
+func goEventsName(r *rdl.Resource) string {
+	return capitalize(string(gomodel.SafeTypeVarName(r.Type))) + "Events"
+}
+
+// clientStreamOpenTemplate checks the response to a stream request, and returns the reader of its events.
+const clientStreamOpenTemplate = `	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		var errobj rdl.ResourceError
+		contentBytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		json.Unmarshal(contentBytes, &errobj)
+		if errobj.Code == 0 {
+			errobj.Code = resp.StatusCode
+		}
+		if errobj.Message == "" {
+			errobj.Message = string(contentBytes)
+		}
+		return nil, errobj
+	}
+	return &%s{body: resp.Body, reader: bufio.NewReader(resp.Body)}, nil`
+
+// clientStreamTemplate is shared by the client flavors. It reads the Server-Sent Events of
+// stream resources.
+const clientStreamTemplate = `{{range streamTypes}}
+//
+// {{.Name}} reads the events of a stream resource. Close it when done.
+//
+type {{.Name}} struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+
+	// LastEventID is the ID of the last event read.
+	LastEventID string
+}
+
+// Next returns the next event, or io.EOF when the stream has ended. An error sent by the server
+// ends the stream, and is returned as an rdl.ResourceError.
+func (events *{{.Name}}) Next() ({{.GoType}}, error) {
+	var data {{.GoType}}
+	for {
+		event, id, payload, err := readEvent(events.reader)
+		if err != nil {
+			return data, err
+		}
+		if id != "" {
+			events.LastEventID = id
+		}
+		switch event {
+		case "", "message":
+			err = json.Unmarshal([]byte(payload), &data)
+			return data, err
+		case "error":
+			var errobj rdl.ResourceError
+			json.Unmarshal([]byte(payload), &errobj)
+			if errobj.Code == 0 {
+				errobj.Code = 500
+			}
+			if errobj.Message == "" {
+				errobj.Message = payload
+			}
+			return data, errobj
+		}
+	}
+}
+
+// Close closes the stream.
+func (events *{{.Name}}) Close() error {
+	return events.body.Close()
+}
+{{end}}{{if streams}}
+//
+// readEvent reads the next Server-Sent Event, skipping comments, and returns its name, ID,
+// and data.
+//
+func readEvent(reader *bufio.Reader) (string, string, string, error) {
+	var event, id string
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", "", "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) > 0 {
+				return event, id, strings.Join(data, "\n"), nil
+			}
+			event = ""
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event = value
+		case "id":
+			id = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
+{{end}}`
+
+// goFormBodies returns true if the schema has resources whose bodies are sent as forms.
+func goFormBodies(reg rdl.TypeRegistry, schema *rdl.Schema) bool {
+	for _, r := range schema.Resources {
+		switch goBodyContentType(reg, r, false) {
+		case "multipart/form-data", "application/x-www-form-urlencoded":
+			return true
+		}
+	}
+	return false
+}
+
+// bodyType returns the type of the body input of a resource.
+func bodyType(r *rdl.Resource) rdl.TypeRef {
+	for _, in := range r.Inputs {
+		if !in.PathParam && in.QueryParam == "" && in.Header == "" {
+			return in.Type
+		}
+	}
+	return ""
+}
+
+// clientBodyTemplate is shared by the client flavors. It encodes the struct bodies of resources
+// that are sent as forms.
+const clientBodyTemplate = `{{if forms}}
+//
+// encodeFormBody encodes the struct v as a form, or a multipart form, via the JSON names of its
+// fields. In a multipart form, the "bytes" fields are sent as file uploads. It returns the content
+// type and the encoded body.
+//
+func encodeFormBody(v interface{}, kinds map[string]string, multipartForm bool) (string, []byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", nil, err
+	}
+	var obj map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		return "", nil, err
+	}
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		if obj[name] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	//array fields are sent as a value (or file) for each of their items
+	formValues := func(name string) []interface{} {
+		if items, ok := obj[name].([]interface{}); ok {
+			return items
+		}
+		return []interface{}{obj[name]}
+	}
+	if !multipartForm {
+		values := url.Values{}
+		for _, name := range names {
+			for _, value := range formValues(name) {
+				values.Add(name, fmt.Sprint(value))
+			}
+		}
+		return "application/x-www-form-urlencoded", []byte(values.Encode()), nil
+	}
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for _, name := range names {
+		for _, value := range formValues(name) {
+			if strings.TrimPrefix(kinds[name], "[]") != "bytes" {
+				if err := writer.WriteField(name, fmt.Sprint(value)); err != nil {
+					return "", nil, err
+				}
+				continue
+			}
+			content, err := base64.StdEncoding.DecodeString(fmt.Sprint(value))
+			if err != nil {
+				return "", nil, err
+			}
+			part, err := writer.CreateFormFile(name, name)
+			if err != nil {
+				return "", nil, err
+			}
+			part.Write(content)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", nil, err
+	}
+	return writer.FormDataContentType(), buf.Bytes(), nil
+}
+{{end}}`
+
+// clientExceptionsTemplate is shared by the client flavors. It has the typed errors of the declared
+// exceptions of the resources.
+const clientExceptionsTemplate = `{{range exceptions}}
+//
+// {{.}}Exception is the error returned when a resource responds with a declared {{.}}
+// exception. Status and Header are those of the response.
+//
+type {{.}}Exception struct {
+	Status int
+	Header http.Header
+	Body   *{{.}}
+}
+
+func (e *{{.}}Exception) Error() string {
+	b, _ := json.Marshal(e.Body)
+	return fmt.Sprintf("%d %s", e.Status, b)
+}
+{{end}}`
+
+// clientOptionsTemplate is shared by the client flavors. It has the options of NewClientWithOptions.
+const clientOptionsTemplate = `
+// ClientOption configures a client created by NewClientWithOptions.
+type ClientOption func(client *{{client}})
+
+// sameTransport returns true if a and b are the same transport. Transports of types that cannot be
+// compared, such as funcs, are never the same.
+func sameTransport(a http.RoundTripper, b http.RoundTripper) (same bool) {
+	defer func() {
+		//comparing values of the same type that cannot be compared panics
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// WithTransport makes the client send its requests with transport instead of http.DefaultTransport.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(client *{{client}}) {
+		client.Transport = transport
+	}
+}
+
+// WithTimeout limits the time of each call, including reading the response body.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(client *{{client}}) {
+		client.Timeout = timeout
+	}
+}
+
+// WithHTTPClient makes the client send its requests with c, whose Transport and Timeout it takes.
+// If c is nil, the client builds its own http.Client, as without the option.
+func WithHTTPClient(c *http.Client) ClientOption {
+	return func(client *{{client}}) {
+		if c != nil {
+			client.httpClient, client.Transport, client.Timeout = c, c.Transport, c.Timeout
+		}
+	}
+}
+
+// WithCredentials adds the credentials to the client, like AddCredentials.
+func WithCredentials(header string, token string) ClientOption {
+	return func(client *{{client}}) {
+		client.AddCredentials(header, token)
+	}
+}
+
+// WithCredentialsProvider sets the provider of the credentials of the requests of the client.
+func WithCredentialsProvider(provider CredentialsProvider) ClientOption {
+	return func(client *{{client}}) {
+		client.Credentials = provider
+	}
+}
+
+// WithMiddleware adds middleware to the client, after the middleware it already has.
+func WithMiddleware(middleware ...ClientMiddleware) ClientOption {
+	return func(client *{{client}}) {
+		client.Middleware = append(client.Middleware, middleware...)
+	}
+}
+
+{{if cache}}
+// WithCache makes the client cache the responses of its GET calls, i.e. in NewLRUResponseCache(1000),
+// by adding CacheMiddleware to its middleware.
+func WithCache(cache ResponseCache) ClientOption {
+	return func(client *{{client}}) {
+		client.Middleware = append(client.Middleware, CacheMiddleware(cache))
+	}
+}
+{{end}}
+// WithSpanHook sets the hook that the calls of the client are reported to.
+func WithSpanHook(hook ClientSpanHook) ClientOption {
+	return func(client *{{client}}) {
+		client.SpanHook = hook
+	}
+}
+
+// WithRetry sets the policy that the client retries failed calls with.
+func WithRetry(policy *RetryPolicy) ClientOption {
+	return func(client *{{client}}) {
+		client.Retry = policy
+	}
+}
+`
+
+// clientMiddlewareTemplate is shared by the client flavors. It has the middleware that the
+// requests of a client pass through, with the resource they call.
+const clientMiddlewareTemplate = `
+//
+// ClientResource describes the resource that a request calls: the name the client calls it by,
+// i.e. "GetItem", its HTTP method, its path template, i.e. "/items/{name}", and the headers that
+// are inputs of the resource.
+//
+type ClientResource struct {
+	Name    string
+	Method  string
+	Path    string
+	Headers []string
+}
+
+// clientResources are the resources of the {{.Name}} schema, by name.
+var clientResources = map[string]*ClientResource{{"{"}}{{range resources}}
+	"{{.Name}}": {Name: "{{.Name}}", Method: "{{.Method}}", Path: "{{.Path}}"{{if .Headers}}, Headers: []string{{"{"}}{{.Headers}}}{{end}}},{{end}}
+}
+
+// clientResourceKey is the context key for the ClientResource of a request.
+type clientResourceKey struct{}
+
+// ClientResourceFromContext returns the resource that a request with the context calls, i.e. in
+// a RoundTripper used as the Transport of the client, or nil.
+func ClientResourceFromContext(ctx context.Context) *ClientResource {
+	r, _ := ctx.Value(clientResourceKey{}).(*ClientResource)
+	return r
+}
+
+// ClientHandler sends a request to a resource and returns the response, like http.RoundTripper.
+type ClientHandler func(resource *ClientResource, req *http.Request) (*http.Response, error)
+
+//
+// ClientMiddleware wraps the handler of the requests of a client, to inspect or modify them and
+// their responses. The middleware of a client is applied in order, the first one outermost, to each
+// attempt at a request, after its credentials and trace context headers have been set.
+//
+type ClientMiddleware func(next ClientHandler) ClientHandler
+
+// roundTrip sends the request to the resource through the middleware of the client, and then with c.
+func (client {{client}}) roundTrip(c *http.Client, resource string, req *http.Request) (*http.Response, error) {
+	r := clientResources[resource]
+	if r == nil {
+		r = &ClientResource{Name: resource, Method: req.Method, Path: req.URL.Path}
+	}
+	handler := func(resource *ClientResource, req *http.Request) (*http.Response, error) {
+		return c.Do(req.WithContext(context.WithValue(req.Context(), clientResourceKey{}, resource)))
+	}
+	for i := len(client.Middleware) - 1; i >= 0; i-- {
+		handler = client.Middleware[i](handler)
+	}
+	return handler(r, req)
+}
+
+// UserAgentMiddleware sets the User-Agent header of the requests to the product, i.e. "myapp/1.2",
+// followed by the name and version of the schema, i.e. "{{.Name}}/{{version}}".
+func UserAgentMiddleware(product string) ClientMiddleware {
+	agent := "{{.Name}}/{{version}}"
+	if product != "" {
+		agent = product + " " + agent
+	}
+	return func(next ClientHandler) ClientHandler {
+		return func(resource *ClientResource, req *http.Request) (*http.Response, error) {
+			req.Header.Set("User-Agent", agent)
+			return next(resource, req)
+		}
+	}
+}
+
+// clientRedactedHeaders are the headers whose values the logging middleware never shows.
+var clientRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// redactHeaders returns a copy of the header, with the values of the redacted headers replaced.
+func redactHeaders(header http.Header, redact []string) http.Header {
+	header = header.Clone()
+	for _, name := range append(clientRedactedHeaders, redact...) {
+		if _, ok := header[http.CanonicalHeaderKey(name)]; ok {
+			header.Set(name, "REDACTED")
+		}
+	}
+	return header
+}
+
+// LoggingMiddleware logs each request with its resource, headers, status and duration to the
+// logger. The values of credential headers, and of the headers named in redact, are not logged.
+func LoggingMiddleware(logger *log.Logger, redact ...string) ClientMiddleware {
+	return func(next ClientHandler) ClientHandler {
+		return func(resource *ClientResource, req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next(resource, req)
+			elapsed := time.Since(start)
+			if err != nil {
+				logger.Printf("%s %s %s %v: %v (%v)", resource.Name, req.Method, req.URL.Redacted(), redactHeaders(req.Header, redact), err, elapsed)
+			} else {
+				logger.Printf("%s %s %s %v: %d (%v)", resource.Name, req.Method, req.URL.Redacted(), redactHeaders(req.Header, redact), resp.StatusCode, elapsed)
+			}
+			return resp, err
+		}
+	}
+}
+
+// DumpMiddleware writes each request and response to w, with their bodies, i.e. to debug a client.
+// The values of credential headers, and of the headers named in redact, are not written. Only the
+// headers of event stream responses are written, since their bodies do not end.
+func DumpMiddleware(w io.Writer, redact ...string) ClientMiddleware {
+	var mu sync.Mutex
+	dump := func(direction string, resource *ClientResource, line string, header http.Header, body []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "%s %s %s\n%s\n", direction, resource.Name, resource.Path, line)
+		redactHeaders(header, redact).Write(w)
+		fmt.Fprintf(w, "\n%s\n", body)
+	}
+	return func(next ClientHandler) ClientHandler {
+		return func(resource *ClientResource, req *http.Request) (*http.Response, error) {
+			body, err := readDumpBody(&req.Body)
+			if err != nil {
+				return nil, err
+			}
+			dump("-->", resource, req.Method+" "+req.URL.Redacted(), req.Header, body)
+			resp, err := next(resource, req)
+			if err != nil {
+				mu.Lock()
+				fmt.Fprintf(w, "<-- %s %v\n", resource.Name, err)
+				mu.Unlock()
+				return resp, err
+			}
+			body = nil
+			if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+				if body, err = readDumpBody(&resp.Body); err != nil {
+					return nil, err
+				}
+			}
+			dump("<--", resource, resp.Proto+" "+resp.Status, resp.Header, body)
+			return resp, nil
+		}
+	}
+}
+
+// readDumpBody reads a request or response body, and replaces it with a reader of its content.
+func readDumpBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(*body)
+	(*body).Close()
+	*body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, err
+}
+`
+
+// clientCacheTemplate is shared by the client flavors. It caches the responses of GET calls as
+// allowed by their Cache-Control headers, and revalidates them with their ETags.
+const clientCacheTemplate = `{{if cache}}
+//
+// CachedResponse is a response kept in a ResponseCache. It is fresh until Expires, and then
+// revalidated with its ETag, if it has one. Vary has the request headers named by its Vary header.
+// A CachedResponse is never modified once it has been stored.
+//
+type CachedResponse struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Expires time.Time
+	Vary    map[string]string
+}
+
+// ResponseCache keeps the responses of a client, by a key made of the URL, the credentials and the
+// header inputs of the request. It must be safe for concurrent use.
+type ResponseCache interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, resp *CachedResponse)
+	Delete(key string)
+}
+
+//
+// LRUResponseCache is a ResponseCache in memory, which keeps up to MaxEntries responses, and
+// evicts the least recently used one to make room for another.
+//
+type LRUResponseCache struct {
+	MaxEntries int
+	mu         sync.Mutex
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type lruCacheEntry struct {
+	key  string
+	resp *CachedResponse
+}
+
+// NewLRUResponseCache returns a ResponseCache of up to maxEntries responses.
+func NewLRUResponseCache(maxEntries int) *LRUResponseCache {
+	return &LRUResponseCache{MaxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (cache *LRUResponseCache) Get(key string) (*CachedResponse, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if e, ok := cache.entries[key]; ok {
+		cache.order.MoveToFront(e)
+		return e.Value.(*lruCacheEntry).resp, true
+	}
+	return nil, false
+}
+
+func (cache *LRUResponseCache) Set(key string, resp *CachedResponse) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if e, ok := cache.entries[key]; ok {
+		e.Value.(*lruCacheEntry).resp = resp
+		cache.order.MoveToFront(e)
+		return
+	}
+	cache.entries[key] = cache.order.PushFront(&lruCacheEntry{key: key, resp: resp})
+	for cache.MaxEntries > 0 && cache.order.Len() > cache.MaxEntries {
+		e := cache.order.Back()
+		cache.order.Remove(e)
+		delete(cache.entries, e.Value.(*lruCacheEntry).key)
+	}
+}
+
+func (cache *LRUResponseCache) Delete(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if e, ok := cache.entries[key]; ok {
+		cache.order.Remove(e)
+		delete(cache.entries, key)
+	}
+}
+
+//
+// CacheMiddleware caches the 200 responses of GET calls in cache, and answers the same calls with
+// them while they are fresh, as given by the max-age of their Cache-Control header. Stale responses
+// with an ETag are revalidated with If-None-Match, and a 304 Not Modified response is answered with
+// the cached one, which the client decodes as usual. Responses with no-store, or with neither a
+// max-age nor an ETag, are not cached, and those with no-cache are always revalidated. Calls with
+// their own If-None-Match header, or with no-store in their Cache-Control header, bypass the cache,
+// and other successful calls to a URL remove its response from the cache.
+//
+func CacheMiddleware(cache ResponseCache) ClientMiddleware {
+	return func(next ClientHandler) ClientHandler {
+		return func(resource *ClientResource, req *http.Request) (*http.Response, error) {
+			if req.Method != "GET" {
+				resp, err := next(resource, req)
+				if err == nil && req.Method != "HEAD" && req.Method != "OPTIONS" && resp.StatusCode < 400 {
+					//the GET resources of the same path are keyed by their own header inputs
+					cache.Delete(responseCacheKey(req, nil))
+					for _, r := range clientResources {
+						if r.Method == "GET" && r.Path == resource.Path {
+							cache.Delete(responseCacheKey(req, r.Headers))
+						}
+					}
+				}
+				return resp, err
+			}
+			key := responseCacheKey(req, resource.Headers)
+			requestControl := req.Header.Get("Cache-Control")
+			if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" || strings.Contains(requestControl, "no-store") {
+				return next(resource, req)
+			}
+			cached, ok := cache.Get(key)
+			if ok && !cached.varies(req) {
+				if time.Now().Before(cached.Expires) && !strings.Contains(requestControl, "no-cache") {
+					return cached.response(req), nil
+				}
+				if etag := cached.Header.Get("ETag"); etag != "" {
+					req = req.Clone(req.Context())
+					req.Header.Set("If-None-Match", etag)
+				}
+			} else {
+				ok = false
+			}
+			resp, err := next(resource, req)
+			if err != nil {
+				return nil, err
+			}
+			if ok && resp.StatusCode == http.StatusNotModified {
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				header := cached.Header.Clone()
+				for name, values := range resp.Header {
+					header[name] = values
+				}
+				revalidated := &CachedResponse{Status: cached.Status, Header: header, Body: cached.Body, Vary: cached.Vary}
+				if expires, cacheable := responseExpiry(header); cacheable {
+					revalidated.Expires = expires
+					cache.Set(key, revalidated)
+				} else {
+					cache.Delete(key)
+				}
+				return revalidated.response(req), nil
+			}
+			if resp.StatusCode != http.StatusOK {
+				return resp, nil
+			}
+			expires, cacheable := responseExpiry(resp.Header)
+			if !cacheable {
+				cache.Delete(key)
+				return resp, nil
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			vary := make(map[string]string)
+			for _, name := range strings.Split(resp.Header.Get("Vary"), ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					vary[name] = req.Header.Get(name)
+				}
+			}
+			cache.Set(key, &CachedResponse{Status: resp.StatusCode, Header: resp.Header.Clone(), Body: body, Expires: expires, Vary: vary})
+			return resp, nil
+		}
+	}
+}
+
+// responseCacheKey returns the key of the response to a request: its URL, and a hash of its
+// credentials and of the values of the header inputs of its resource.
+func responseCacheKey(req *http.Request, headers []string) string {
+	h := sha256.New()
+	for _, name := range append([]string{"Authorization", "Proxy-Authorization", "Cookie"}, headers...) {
+		io.WriteString(h, name+": "+strings.Join(req.Header.Values(name), ", ")+"\n")
+	}
+	return req.URL.String() + " " + hex.EncodeToString(h.Sum(nil))
+}
+
+// responseExpiry returns the time until which a response with the header is fresh, and whether
+// it can be cached at all.
+func responseExpiry(header http.Header) (time.Time, bool) {
+	now := time.Now()
+	maxAge := -1
+	for _, directive := range strings.Split(strings.ToLower(header.Get("Cache-Control")), ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "no-store":
+			return now, false
+		case directive == "no-cache":
+			maxAge = 0
+		case strings.HasPrefix(directive, "max-age=") && maxAge != 0:
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				maxAge = seconds
+			}
+		}
+	}
+	if strings.Contains(header.Get("Vary"), "*") {
+		return now, false
+	}
+	if maxAge < 0 {
+		return now, header.Get("ETag") != ""
+	}
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil {
+		maxAge -= age
+	}
+	return now.Add(time.Duration(maxAge) * time.Second), maxAge > 0 || header.Get("ETag") != ""
+}
+
+// varies returns true if the request differs from the one of the cached response in a header
+// named by its Vary header.
+func (cached *CachedResponse) varies(req *http.Request) bool {
+	for name, value := range cached.Vary {
+		if req.Header.Get(name) != value {
+			return true
+		}
+	}
+	return false
+}
+
+// response returns a new response to the request with the cached one.
+func (cached *CachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", cached.Status, http.StatusText(cached.Status)),
+		StatusCode:    cached.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cached.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(cached.Body)),
+		ContentLength: int64(len(cached.Body)),
+		Request:       req,
+	}
+}
+{{end}}`
+
+// clientCassetteTemplate is shared by the client flavors. It records the calls of a client to a
+// cassette file, and replays them in tests.
+const clientCassetteTemplate = `{{if cassette}}
+// clientResultTypes return new values of the types that the JSON responses of the resources are
+// decoded into, by resource name.
+var clientResultTypes = map[string]func() interface{}{{"{"}}{{range resources}}{{if .Result}}
+	"{{.Name}}": func() interface{} { return new({{.Result}}) },{{end}}{{end}}
+}
+
+// CassetteRequest is a recorded request, with its query and JSON body normalized. A body that is
+// not valid UTF-8 is recorded base64 encoded, with a BodyEncoding of "base64".
+type CassetteRequest struct {
+	Method       string      ` + "`" + `json:"method"` + "`" + `
+	Path         string      ` + "`" + `json:"path"` + "`" + `
+	Query        string      ` + "`" + `json:"query,omitempty"` + "`" + `
+	Header       http.Header ` + "`" + `json:"header,omitempty"` + "`" + `
+	Body         string      ` + "`" + `json:"body,omitempty"` + "`" + `
+	BodyEncoding string      ` + "`" + `json:"body_encoding,omitempty"` + "`" + `
+}
+
+// CassetteResponse is a recorded response. A body that is not valid UTF-8 is recorded base64
+// encoded, with a BodyEncoding of "base64".
+type CassetteResponse struct {
+	Status       int         ` + "`" + `json:"status"` + "`" + `
+	Header       http.Header ` + "`" + `json:"header,omitempty"` + "`" + `
+	Body         string      ` + "`" + `json:"body,omitempty"` + "`" + `
+	BodyEncoding string      ` + "`" + `json:"body_encoding,omitempty"` + "`" + `
+}
+
+// Bytes returns the recorded body of the response.
+func (resp *CassetteResponse) Bytes() ([]byte, error) {
+	if resp.BodyEncoding == "base64" {
+		return base64.StdEncoding.DecodeString(resp.Body)
+	}
+	return []byte(resp.Body), nil
+}
+
+// encodeCassetteBody returns a body to record, and its encoding: "base64" if it is not valid UTF-8,
+// which a JSON string cannot hold.
+func encodeCassetteBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// CassetteInteraction is a call to a resource recorded in a cassette.
+type CassetteInteraction struct {
+	Resource string           ` + "`" + `json:"resource"` + "`" + `
+	Request  CassetteRequest  ` + "`" + `json:"request"` + "`" + `
+	Response CassetteResponse ` + "`" + `json:"response"` + "`" + `
+	key      string
+	played   bool
+}
+
+// CassetteMode is whether a CassetteTransport records or replays.
+type CassetteMode int
+
+const (
+	CassetteReplay CassetteMode = iota
+	CassetteRecord
+)
+
+//
+// CassetteTransport is an http.RoundTripper that records the calls of a client, made with
+// Transport, to a JSON cassette file, or replays them from it without a network connection, i.e.
+// in tests. A call is matched to a recorded one by its resource, method, path, query, JSON body,
+// and the header inputs of the resource other than IgnoreHeaders. Calls are replayed in the order
+// they were recorded, and a call repeated more often than recorded gets the last response again.
+// A call that matches no recorded call fails with an error. The values of credential headers, and
+// of the headers named in Redact, are not recorded. With Validate, recorded responses are checked
+// to decode into the types of their resources. Calls to stream resources, whose responses do not
+// end, cannot be recorded or replayed, and fail with an error.
+//
+type CassetteTransport struct {
+	Path          string
+	Mode          CassetteMode
+	Transport     http.RoundTripper
+	Redact        []string
+	IgnoreHeaders []string
+	Validate      bool
+	mu            sync.Mutex
+	interactions  []*CassetteInteraction
+}
+
+// NewCassetteRecorder returns a CassetteTransport that makes the calls with transport, or
+// http.DefaultTransport if it is nil, and records them to the file at path.
+func NewCassetteRecorder(path string, transport http.RoundTripper) *CassetteTransport {
+	return &CassetteTransport{Path: path, Mode: CassetteRecord, Transport: transport}
+}
+
+// NewCassetteReplayer returns a CassetteTransport that replays the calls recorded in the file at path.
+func NewCassetteReplayer(path string) (*CassetteTransport, error) {
+	t := &CassetteTransport{Path: path, Mode: CassetteReplay}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette struct {
+		Interactions []*CassetteInteraction ` + "`" + `json:"interactions"` + "`" + `
+	}
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("cassette %s: %v", path, err)
+	}
+	t.interactions = cassette.Interactions
+	return t, nil
+}
+
+// Unplayed returns the recorded calls that have not been replayed, i.e. to check that a test
+// made all of them.
+func (t *CassetteTransport) Unplayed() []*CassetteInteraction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var unplayed []*CassetteInteraction
+	for _, interaction := range t.interactions {
+		if !interaction.played {
+			unplayed = append(unplayed, interaction)
+		}
+	}
+	return unplayed
+}
+
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resource := ""
+	if r := ClientResourceFromContext(req.Context()); r != nil {
+		resource = r.Name
+	}
+	if strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		return nil, fmt.Errorf("cassette %s: %s is a stream, which cannot be recorded or replayed", t.Path, resource)
+	}
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = data
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	recorded := CassetteRequest{Method: req.Method, Path: req.URL.EscapedPath(), Query: req.URL.Query().Encode(), Header: redactHeaders(req.Header, t.Redact)}
+	recorded.Body, recorded.BodyEncoding = encodeCassetteBody(normalizeCassetteBody(req.Header, body))
+	key := t.key(resource, &recorded)
+	if t.Mode == CassetteRecord {
+		return t.record(resource, key, req, recorded)
+	}
+	t.mu.Lock()
+	var match *CassetteInteraction
+	for _, interaction := range t.interactions {
+		if interaction.key == "" {
+			interaction.key = t.key(interaction.Resource, &interaction.Request)
+		}
+		if interaction.key == key {
+			match = interaction
+			if !interaction.played {
+				break
+			}
+		}
+	}
+	if match != nil {
+		match.played = true
+	}
+	t.mu.Unlock()
+	if match == nil {
+		return nil, fmt.Errorf("cassette %s: no recorded call matches %s %s %s?%s %s", t.Path, resource, recorded.Method, recorded.Path, recorded.Query, recorded.Body)
+	}
+	if err := t.validate(match.Resource, &match.Response); err != nil {
+		return nil, err
+	}
+	respBody, err := match.Response.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("cassette %s: the response of %s is not valid: %v", t.Path, match.Resource, err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", match.Response.Status, http.StatusText(match.Response.Status)),
+		StatusCode:    match.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        match.Response.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+func (t *CassetteTransport) record(resource string, key string, req *http.Request, recorded CassetteRequest) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body.Close()
+		return nil, fmt.Errorf("cassette %s: %s is a stream, which cannot be recorded or replayed", t.Path, resource)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	interaction := &CassetteInteraction{Resource: resource, Request: recorded, Response: CassetteResponse{Status: resp.StatusCode, Header: redactHeaders(resp.Header, t.Redact)}, key: key}
+	interaction.Response.Body, interaction.Response.BodyEncoding = encodeCassetteBody(body)
+	if err := t.validate(resource, &interaction.Response); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interactions = append(t.interactions, interaction)
+	data, err := json.MarshalIndent(map[string]interface{}{"interactions": t.interactions}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(t.Path, data, 0644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// key returns the string that calls are matched by.
+func (t *CassetteTransport) key(resource string, recorded *CassetteRequest) string {
+	key := resource + " " + recorded.Method + " " + recorded.Path + "?" + recorded.Query + " " + recorded.Body
+	if r := clientResources[resource]; r != nil {
+		for _, name := range r.Headers {
+			ignored := false
+			for _, ignore := range t.IgnoreHeaders {
+				ignored = ignored || strings.EqualFold(ignore, name)
+			}
+			if !ignored {
+				key += " " + name + ": " + recorded.Header.Get(name)
+			}
+		}
+	}
+	return key
+}
+
+// validate checks that a successful JSON response decodes into the type of its resource.
+func (t *CassetteTransport) validate(resource string, resp *CassetteResponse) error {
+	newResult := clientResultTypes[resource]
+	if !t.Validate || newResult == nil || resp.Status < 200 || resp.Status > 299 || resp.Body == "" {
+		return nil
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return nil
+	}
+	body, err := resp.Bytes()
+	if err == nil {
+		err = json.Unmarshal(body, newResult())
+	}
+	if err != nil {
+		return fmt.Errorf("cassette %s: the response of %s is not valid: %v", t.Path, resource, err)
+	}
+	return nil
+}
+
+// normalizeCassetteBody returns the body of a request, with the keys of its objects sorted if it is JSON.
+func normalizeCassetteBody(header http.Header, body []byte) []byte {
+	if len(body) == 0 {
+		return nil
+	}
+	if strings.Contains(header.Get("Content-Type"), "json") {
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err == nil {
+			if b, err := json.Marshal(v); err == nil {
+				return b
+			}
+		}
+	}
+	return body
+}
+{{end}}`
+
+// clientCredentialsTemplate is shared by the client flavors. It has the providers of the
+// credentials that are sent with each request, and may change between them.
+const clientCredentialsTemplate = `
+//
+// CredentialsProvider supplies the credentials of the requests of a client, as a header and its value.
+// A header named "Cookie.name" sends the value as the cookie of that name. Credentials is called for
+// each request. When a request gets 401 Unauthorized, Refresh is called, and the request is made once
+// more if the credentials have changed.
+//
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (header string, value string, err error)
+	Refresh(ctx context.Context) error
+}
+
+type staticCredentials struct {
+	header string
+	value  string
+}
+
+// StaticCredentials returns a provider of credentials that never change, like AddCredentials.
+func StaticCredentials(header string, value string) CredentialsProvider {
+	return staticCredentials{header: header, value: value}
+}
+
+func (c staticCredentials) Credentials(ctx context.Context) (string, string, error) {
+	return c.header, c.value, nil
+}
+
+func (c staticCredentials) Refresh(ctx context.Context) error {
+	return nil
+}
+
+//
+// FileCredentials provides credentials read from a file, i.e. a token that is rotated on disk. The
+// file is read again at most once per CheckInterval, and on Refresh. The value is the content of the
+// file without surrounding white space, after Prefix, i.e. "Bearer ". If the file cannot be read, the
+// last value read is used.
+//
+type FileCredentials struct {
+	Header        string
+	Prefix        string
+	Path          string
+	CheckInterval time.Duration
+	mu            sync.Mutex
+	value         string
+	checked       time.Time
+}
+
+// NewFileCredentials returns a provider of the credentials in the file at path, sent in the header.
+func NewFileCredentials(header string, path string) *FileCredentials {
+	return &FileCredentials{Header: header, Path: path, CheckInterval: time.Second}
+}
+
+func (c *FileCredentials) Credentials(ctx context.Context) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checked.IsZero() || time.Since(c.checked) >= c.CheckInterval {
+		if err := c.load(); err != nil && c.value == "" {
+			return "", "", err
+		}
+	}
+	return c.Header, c.Prefix + c.value, nil
+}
+
+func (c *FileCredentials) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.load()
+}
+
+func (c *FileCredentials) load() error {
+	c.checked = time.Now()
+	data, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return fmt.Errorf("no credentials in %s", c.Path)
+	}
+	c.value = value
+	return nil
+}
+
+// CredentialsFunc fetches credentials, i.e. from a token service, with the time they expire at. A
+// zero expiry means that they do not expire.
+type CredentialsFunc func(ctx context.Context) (header string, value string, expiry time.Time, err error)
+
+//
+// CachingCredentials provides the credentials fetched by a CredentialsFunc, and keeps them until
+// Early before they expire, so that they are refreshed before the server rejects them. Concurrent
+// requests wait for one fetch.
+//
+type CachingCredentials struct {
+	Fetch  CredentialsFunc
+	Early  time.Duration
+	mu     sync.Mutex
+	header string
+	value  string
+	expiry time.Time
+	valid  bool
+}
+
+// NewCachingCredentials returns a provider that caches the credentials fetched by fetch, and
+// fetches new ones when they are within early of their expiry.
+func NewCachingCredentials(fetch CredentialsFunc, early time.Duration) *CachingCredentials {
+	return &CachingCredentials{Fetch: fetch, Early: early}
+}
+
+func (c *CachingCredentials) Credentials(ctx context.Context) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.valid || (!c.expiry.IsZero() && time.Now().Add(c.Early).After(c.expiry)) {
+		if err := c.fetch(ctx); err != nil {
+			return "", "", err
+		}
+	}
+	return c.header, c.value, nil
+}
+
+func (c *CachingCredentials) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetch(ctx)
+}
+
+func (c *CachingCredentials) fetch(ctx context.Context) error {
+	header, value, expiry, err := c.Fetch(ctx)
+	if err != nil {
+		c.valid = false
+		return err
+	}
+	c.header, c.value, c.expiry, c.valid = header, value, expiry, true
+	return nil
+}
+
+// doWithCredentials makes the request with do, with the credentials of the provider if there is one.
+// If the response is 401 Unauthorized, it refreshes the credentials and makes the request once more.
+func doWithCredentials(ctx context.Context, provider CredentialsProvider, req *http.Request, do func(ctx context.Context, req *http.Request) (*http.Response, error)) (*http.Response, error) {
+	if provider == nil {
+		return do(ctx, req)
+	}
+	header, value, err := provider.Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(ctx, withCredentials(req, header, value))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	if provider.Refresh(ctx) != nil {
+		return resp, nil
+	}
+	refreshedHeader, refreshedValue, err := provider.Credentials(ctx)
+	if err != nil || (refreshedHeader == header && refreshedValue == value) {
+		return resp, nil
+	}
+	retry := withCredentials(req, refreshedHeader, refreshedValue)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return do(ctx, retry)
+}
+
+// withCredentials returns a copy of the request with the credentials.
+func withCredentials(req *http.Request, header string, value string) *http.Request {
+	req = req.Clone(req.Context())
+	if strings.HasPrefix(header, "Cookie.") {
+		req.Header.Add("Cookie", strings.TrimPrefix(header, "Cookie.")+"="+value)
+	} else {
+		req.Header.Set(header, value)
+	}
+	return req
+}
+`
+
+// clientRetryTemplate is shared by the client flavors. It retries the calls that are safe to
+// repeat, as configured by the Retry policy of the client.
+const clientRetryTemplate = `
+//
+// RetryPolicy retries the calls that fail with a connection error, 429 Too Many Requests, or a
+// 5xx status, up to MaxAttempts attempts in all. The client waits for the time in the Retry-After
+// header of the response if there is one, and otherwise for an exponential backoff from MinBackoff
+// up to MaxBackoff, with full jitter. Either wait is capped at MaxDelay unless it is 0, and the
+// client stops retrying when the wait would end after the deadline of the context of the call.
+// Retryable decides which calls may be repeated; by default GET, HEAD, PUT, DELETE and OPTIONS
+// calls, calls with an Idempotency-Key header, and calls to the resources annotated with
+// x_idempotent are. Calls with a body that cannot be rewound are never retried.
+//
+type RetryPolicy struct {
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxDelay    time.Duration
+	Retryable   func(resource string, req *http.Request) bool
+}
+
+// NewRetryPolicy returns a RetryPolicy of up to 3 attempts, with a backoff from 100ms to 5s, and
+// waits of at most 30s.
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: 3, MinBackoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second, MaxDelay: 30 * time.Second}
+}
+
+// idempotentResources are the resources annotated with x_idempotent.
+var idempotentResources = map[string]bool{{"{"}}{{range idempotent}}
+	"{{.}}": true,{{end}}
+}
+
+// IdempotentRequest is the default Retryable function of a RetryPolicy.
+func IdempotentRequest(resource string, req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return idempotentResources[resource] || req.Header.Get("Idempotency-Key") != ""
+}
+
+// retryDelay returns the time to wait before the next attempt at the request, up to MaxDelay, and
+// whether there should be one.
+func (policy *RetryPolicy) retryDelay(resource string, req *http.Request, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	delay, retry := policy.uncappedDelay(resource, req, attempt, resp, err)
+	if retry && policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay, retry
+}
+
+func (policy *RetryPolicy) uncappedDelay(resource string, req *http.Request, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if policy == nil || attempt >= policy.MaxAttempts {
+		return 0, false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false
+	}
+	if err == nil && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return 0, false
+	}
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IdempotentRequest
+	}
+	if !retryable(resource, req) {
+		return 0, false
+	}
+	if resp != nil {
+		if after := resp.Header.Get("Retry-After"); after != "" {
+			if seconds, err := strconv.Atoi(after); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second, true
+			}
+			if t, err := http.ParseTime(after); err == nil {
+				if d := time.Until(t); d > 0 {
+					return d, true
+				}
+				return 0, true
+			}
+		}
+	}
+	backoff := policy.MinBackoff
+	for i := 1; i < attempt && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	clientRand.Lock()
+	defer clientRand.Unlock()
+	return time.Duration(clientRand.Int63n(int64(backoff) + 1)), true
+}
+
+// doWithRetries makes the request with do, and repeats it as the policy allows. The body of the
+// request is rewound for each attempt, and the retries stop when ctx is done, or would be after
+// the wait.
+func doWithRetries(ctx context.Context, policy *RetryPolicy, resource string, req *http.Request, do func(ctx context.Context, req *http.Request) (*http.Response, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := do(ctx, req)
+		delay, retry := policy.retryDelay(resource, req, attempt, resp, err)
+		if !retry || ctx.Err() != nil {
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+`
+
+// clientTracingTemplate is shared by the client flavors. It injects the W3C trace context from
+// the caller's context into requests, and reports client spans to an optional hook.
+const clientTracingTemplate = `
+//
+// ClientSpan describes one call to a resource. The IDs are the hex encoded W3C trace context IDs:
+// ParentID is the span ID of the caller's span, and is empty for a new trace. End, Status, and Err
+// are set when the response headers have been received, or the call has failed.
+//
+type ClientSpan struct {
+	Resource   string
+	Method     string
+	URL        string
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	TraceState string
+	Start      time.Time
+	End        time.Time
+	Status     int
+	Err        error
+}
+
+//
+// ClientSpanHook is the interface to plug a tracer into the client. SpanStart may return a
+// derived context, which is then used for the request. The trace context of the request is taken
+// from it, if the hook sets one with ContextWithTraceParent, or else from the span.
+//
+type ClientSpanHook interface {
+	SpanStart(ctx context.Context, span *ClientSpan) context.Context
+	SpanEnd(ctx context.Context, span *ClientSpan)
+}
+
+//
+// ClientSpanRecorder is a ClientSpanHook that keeps the completed spans in memory, i.e. for tests.
+//
+type ClientSpanRecorder struct {
+	mu    sync.Mutex
+	spans []ClientSpan
+}
+
+func (recorder *ClientSpanRecorder) SpanStart(ctx context.Context, span *ClientSpan) context.Context {
+	return ctx
+}
+
+func (recorder *ClientSpanRecorder) SpanEnd(ctx context.Context, span *ClientSpan) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.spans = append(recorder.spans, *span)
+}
+
+// Spans returns the spans completed so far, in order of completion.
+func (recorder *ClientSpanRecorder) Spans() []ClientSpan {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return append([]ClientSpan(nil), recorder.spans...)
+}
+
+//
+// startSpan sets the traceparent and tracestate headers of the request. Without a span hook, the
+// trace context of the caller is passed on as is. Otherwise a new span is started as a child
+// of it, or as the root of a new trace, and the headers are set once the hook has started it:
+// from the trace context that the hook puts in the context it returns, with
+// ContextWithTraceParent, or else from the IDs of the span, which the hook may change.
+//
+func (client {{client}}) startSpan(ctx context.Context, resource string, req *http.Request) (context.Context, *ClientSpan) {
+	traceParent, traceState := TraceParentFromContext(ctx)
+	if client.SpanHook == nil {
+		setTraceHeaders(req, traceParent, traceState)
+		return ctx, nil
+	}
+	traceID, parentID, flags := "", "", "01"
+	if parts := strings.Split(traceParent, "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 && len(parts[3]) == 2 {
+		traceID, parentID, flags = parts[1], parts[2], parts[3]
+	} else {
+		traceID, traceState = clientRandomHex(16), ""
+	}
+	span := &ClientSpan{Resource: resource, Method: req.Method, URL: req.URL.String(), TraceID: traceID, SpanID: clientRandomHex(8), ParentID: parentID, TraceState: traceState, Start: time.Now()}
+	ctx = client.SpanHook.SpanStart(ctx, span)
+	if hookParent, hookState := TraceParentFromContext(ctx); hookParent != traceParent || hookState != traceState {
+		setTraceHeaders(req, hookParent, hookState)
+	} else {
+		setTraceHeaders(req, "00-"+span.TraceID+"-"+span.SpanID+"-"+flags, span.TraceState)
+	}
+	return ctx, span
+}
+
+// setTraceHeaders sets the traceparent and tracestate headers of the request, if there is a
+// trace parent.
+func setTraceHeaders(req *http.Request, traceParent string, traceState string) {
+	if traceParent == "" {
+		return
+	}
+	req.Header.Set("traceparent", traceParent)
+	if traceState != "" {
+		req.Header.Set("tracestate", traceState)
+	} else {
+		req.Header.Del("tracestate")
+	}
+}
+
+func (client {{client}}) endSpan(ctx context.Context, span *ClientSpan, resp *http.Response, err error) {
+	if span == nil {
+		return
+	}
+	span.End = time.Now()
+	if resp != nil {
+		span.Status = resp.StatusCode
+	}
+	span.Err = err
+	client.SpanHook.SpanEnd(ctx, span)
+}
+
+// clientRand is the source of the random IDs of spans and of the jitter of retries. It is seeded
+// for each process, which the global source of math/rand is not before Go 1.20.
+var clientRand = struct {
+	sync.Mutex
+	*mathrand.Rand
+}{Rand: mathrand.New(mathrand.NewSource(time.Now().UnixNano()))}
+
+// clientRandomHex returns n random bytes, hex encoded.
+func clientRandomHex(n int) string {
+	clientRand.Lock()
+	defer clientRand.Unlock()
+	b := make([]byte, n)
+	clientRand.Read(b)
+	return hex.EncodeToString(b)
+}
+`
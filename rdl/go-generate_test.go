@@ -0,0 +1,240 @@
+// Copyright 2015 Yahoo Inc.
+// Licensed under the terms of the Apache version 2.0 license. See LICENSE file for terms.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ardielle/ardielle-go/rdl"
+)
+
+// goTestModule is the go.mod of the modules that the generated code is checked in. Their go.sum
+// is testdata/go.sum.
+const goTestModule = `module %s
+
+go 1.23
+
+require (
+	github.com/ardielle/ardielle-go v1.5.2
+	github.com/dimfeld/httptreemux v5.0.1+incompatible
+	golang.org/x/crypto v0.31.0
+)
+`
+
+var update = flag.Bool("update", false, "update the generated code in testdata")
+
+// goFlavor is a combination of the Go generators and their options, whose code is generated into
+// one package.
+type goFlavor struct {
+	name       string
+	schema     string
+	generators []func(opts *generateOptions) error
+	options    func(opts *generateOptions)
+	test       bool
+	// without are the imports that the generated files must not have, by file name.
+	without map[string][]string
+}
+
+var goFlavors = []goFlavor{
+	{
+		name:       "plain",
+		schema:     "testdata/store.rdl",
+		generators: []func(*generateOptions) error{GenerateGoModel, GenerateGoServer, GenerateGoClient},
+		options:    func(opts *generateOptions) { opts.clientCache, opts.clientCassette = true, true },
+		without:    map[string][]string{"store_server.go": {"golang.org/x/crypto/bcrypt", "crypto/rsa", "crypto/x509"}},
+	},
+	{
+		name:       "reqrep",
+		schema:     "testdata/store.rdl",
+		generators: []func(*generateOptions) error{GenerateGoModel, GenerateGoServer, GenerateGoClient},
+		options: func(opts *generateOptions) {
+			opts.requestResponse, opts.clientCache, opts.clientCassette = true, true, true
+		},
+	},
+	{
+		name:       "fake",
+		schema:     "testdata/store.rdl",
+		generators: []func(*generateOptions) error{GenerateGoModel, GenerateGoClient},
+		options:    func(opts *generateOptions) { opts.clientFake = true },
+	},
+	{
+		name:       "fake-reqrep",
+		schema:     "testdata/store.rdl",
+		generators: []func(*generateOptions) error{GenerateGoModel, GenerateGoClient},
+		options:    func(opts *generateOptions) { opts.clientFake, opts.requestResponse = true, true },
+	},
+	{
+		name:       "in-process",
+		schema:     "testdata/store.rdl",
+		generators: []func(*generateOptions) error{GenerateGoModel, GenerateGoServer, GenerateGoClient},
+		options: func(opts *generateOptions) {
+			opts.inProcessClient, opts.clientFake, opts.authenticators = true, true, true
+		},
+	},
+	{
+		name:       "in-process-reqrep",
+		schema:     "testdata/store.rdl",
+		generators: []func(*generateOptions) error{GenerateGoModel, GenerateGoServer, GenerateGoClient},
+		options: func(opts *generateOptions) {
+			opts.inProcessClient, opts.clientFake, opts.requestResponse = true, true, true
+		},
+	},
+	{
+		name:       "basic",
+		schema:     "testdata/basic.rdl",
+		generators: []func(*generateOptions) error{GenerateGoModel, GenerateGoServer, GenerateGoClient},
+		options:    func(opts *generateOptions) { opts.inProcessClient, opts.clientFake = true, true },
+		without: map[string][]string{
+			"basic_client.go": {"bufio", "container/list", "crypto/rand", "crypto/sha256", "mime/multipart",
+				"net/http/httputil", "os", "reflect", "sort", "unicode/utf8", "iter"},
+			"basic_inprocess.go": {"iter"},
+		},
+	},
+	{
+		name:       "project",
+		schema:     "testdata/store.rdl",
+		generators: []func(*generateOptions) error{GenerateGoServerProject},
+		options:    func(opts *generateOptions) { opts.ns = "store" },
+		test:       true,
+	},
+	{
+		name:       "project-reqrep",
+		schema:     "testdata/store.rdl",
+		generators: []func(*generateOptions) error{GenerateGoServerProject},
+		options:    func(opts *generateOptions) { opts.ns, opts.requestResponse = "store", true },
+		test:       true,
+	},
+}
+
+// TestGoGenerators generates the code of each flavor of the Go generators for an annotated schema,
+// and checks it with go vet, and with go test if the flavor generates tests, which runs each of
+// their benchmarks once.
+func TestGoGenerators(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the checks of the generated code in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("skipping the checks of the generated code without the go command")
+	}
+	for _, flavor := range goFlavors {
+		flavor := flavor
+		t.Run(flavor.name, func(t *testing.T) {
+			t.Parallel()
+			dir := generateGoFlavor(t, flavor)
+			checkImports(t, dir, flavor.without)
+			runGo(t, dir, "vet", "./...")
+			if flavor.test {
+				runGo(t, dir, "test", "-bench", ".", "-benchtime", "1x", "./...")
+			}
+		})
+	}
+}
+
+// TestGoClientGolden checks that the client generated for testdata/basic.rdl in testdata/basic,
+// which has the benchmarks of the client, is up to date. Run go test -update to regenerate it.
+func TestGoClientGolden(t *testing.T) {
+	dir := t.TempDir()
+	schema, err := rdl.ParseRDLFile("testdata/basic.rdl", false, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &generateOptions{schema: schema, banner: "rdl (test)", dirName: dir, librdl: RdlGoImport}
+	for _, generate := range []func(*generateOptions) error{GenerateGoModel, GenerateGoClient} {
+		if err := generate(opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range files {
+		generated, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := filepath.Join("testdata", "basic", filepath.Base(path))
+		if *update {
+			if err := ioutil.WriteFile(golden, generated, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if expected, err := ioutil.ReadFile(golden); err != nil || !bytes.Equal(generated, expected) {
+			t.Errorf("%s is not up to date, run go test -update to regenerate it", golden)
+		}
+	}
+}
+
+// generateGoFlavor generates the code of the flavor into a new module, and returns its directory.
+func generateGoFlavor(t *testing.T, flavor goFlavor) string {
+	dir := t.TempDir()
+	module := "gentest"
+	opts := &generateOptions{schemaFile: flavor.schema, banner: "rdl (test)", dirName: dir, librdl: RdlGoImport}
+	if flavor.options != nil {
+		flavor.options(opts)
+	}
+	if opts.ns != "" {
+		module = opts.ns
+	}
+	sum, err := ioutil.ReadFile("testdata/go.sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.sum"), sum, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(fmt.Sprintf(goTestModule, module)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := rdl.ParseRDLFile(flavor.schema, false, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.schema = schema
+	for _, generate := range flavor.generators {
+		if err := generate(opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// checkImports fails the test if a Go file in dir imports one of its packages in without.
+func checkImports(t *testing.T, dir string, without map[string][]string) {
+	fset := token.NewFileSet()
+	for name, packages := range without {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, spec := range file.Imports {
+			for _, p := range packages {
+				if strings.Trim(spec.Path.Value, `"`) == p {
+					t.Errorf("%s imports %s", name, p)
+				}
+			}
+		}
+	}
+}
+
+// runGo runs the go command in dir, and fails the test with its output if it fails.
+func runGo(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go %v: %v\n%s", args, err, out)
+	}
+}
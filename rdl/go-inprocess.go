@@ -0,0 +1,578 @@
+// Copyright 2015 Yahoo Inc.
+// Licensed under the terms of the Apache version 2.0 license. See LICENSE file for terms.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/ardielle/ardielle-go/gen/gomodel"
+	"github.com/ardielle/ardielle-go/rdl"
+)
+
+type inProcessClientGenerator struct {
+	registry        rdl.TypeRegistry
+	schema          *rdl.Schema
+	name            string
+	banner          string
+	precise         bool
+	ns              string
+	librdl          string
+	requestResponse bool
+	clientFake      bool
+}
+
+const inProcessClientTemplate = `{{header}}
+
+package {{package}}
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	rdl "{{rdlruntime}}"
+	"io"{{if paginated}}
+	"iter"{{end}}
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var _ = bufio.NewReader
+var _ = context.Background
+var _ = fmt.Sprint
+var _ = io.EOF
+{{if api}}
+var _ {{api}} = {{client}}{}
+{{end}}
+//
+// {{client}} makes the calls of {{name}}Client directly on a {{cName}}Handler in the same
+// process, i.e. in a monolith or an integration test, without HTTP. Each call gets a synthetic
+// rdl.ResourceContext, whose request comes from the address "in-process:0" and carries the
+// credentials of the client, and runs the authenticators and authorizer of the resource. The
+// values of the calls are passed as JSON, so that the caller and the implementation never share
+// them, and errors are returned as {{name}}Client returns the error responses of the HTTP handler:
+// an *rdl.ResourceError of the implementation keeps its code, and other errors are 500s.
+//
+// The calls bypass the HTTP handler, and with it the x_rate_limit, x_rate_burst and
+// x_max_in_flight limits, the x_max_body limits and x_timeout deadlines, the x_idempotency_key
+// replays, the x_conditional ETags and the x_fields of the resources: the implementation is
+// called however often and for however long the caller does. To apply them, give a
+// {{name}}Client the NewInProcessTransport of the handler instead.
+//
+type {{client}} struct {
+	CredsHeader *string
+	CredsToken  *string
+	adaptor     {{adaptor}}{{if not request}}
+	ctx         context.Context{{end}}
+}
+
+// NewInProcessClient creates a client that calls impl directly, authorized by authz and
+// authenticated by authns as by the handler that InitWithConfig returns for them. No limits,
+// deadlines or idempotency keys are applied to the calls.
+func NewInProcessClient(impl {{cName}}Handler, authz rdl.Authorizer, authns ...rdl.Authenticator) {{client}} {
+	return {{client}}{adaptor: {{adaptor}}{impl: impl, authorizer: authz, authenticators: authns}}
+}
+{{if not request}}
+// WithContext returns a copy of the client that makes its calls with the specified context, which
+// is the context of the request of each call.
+func (client {{client}}) WithContext(ctx context.Context) {{client}} {
+	client.ctx = ctx
+	return client
+}
+{{end}}
+// AddCredentials adds the credentials to the client for subsequent calls.
+func (client *{{client}}) AddCredentials(header string, token string) {
+	client.CredsHeader = &header
+	client.CredsToken = &token
+}
+
+// AddBearerToken adds an "Authorization: Bearer" token, i.e. a JWT, to the client for subsequent calls.
+func (client *{{client}}) AddBearerToken(token string) {
+	client.AddCredentials("Authorization", "Bearer "+token)
+}
+
+// AddBasicCredentials adds HTTP Basic credentials to the client for subsequent calls.
+func (client *{{client}}) AddBasicCredentials(name string, password string) {
+	client.AddCredentials("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(name+":"+password)))
+}
+
+// resourceContext returns the context of a call to the resource at path, with the path parameters
+// of the call.
+func (client {{client}}) resourceContext(ctx context.Context, method string, path string, params map[string]string) *rdl.ResourceContext {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for name, value := range params {
+		path = strings.Replace(path, "{"+name+"}", url.PathEscape(value), 1)
+	}
+	request, _ := http.NewRequestWithContext(ctx, method, path, nil)
+	request.RemoteAddr = "in-process:0"
+	request.RequestURI = path
+	if client.CredsHeader != nil && client.CredsToken != nil {
+		if strings.HasPrefix(*client.CredsHeader, "Cookie.") {
+			request.Header.Add("Cookie", (*client.CredsHeader)[7:]+"="+*client.CredsToken)
+		} else {
+			request.Header.Add(*client.CredsHeader, *client.CredsToken)
+		}
+	}
+	return &rdl.ResourceContext{Writer: &inProcessContextWriter{header: make(http.Header)}, Request: request, Params: params}
+}
+
+func (client {{client}}) authenticate(context *rdl.ResourceContext) error {
+	if !client.adaptor.authenticate(context) {
+		return &rdl.ResourceError{Code: http.StatusUnauthorized, Message: "Unauthorized"}
+	}
+	return nil
+}
+
+func (client {{client}}) authorize(context *rdl.ResourceContext, action string, resource string, domain string) error {
+	if !client.adaptor.authorize(context, action, resource, domain) {
+		return &rdl.ResourceError{Code: http.StatusForbidden, Message: "Forbidden"}
+	}
+	return nil
+}
+
+// inProcessContextWriter is the Writer of the context of a call, whose headers and body are dropped.
+type inProcessContextWriter struct {
+	header http.Header
+}
+
+func (w *inProcessContextWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *inProcessContextWriter) WriteHeader(status int) {
+}
+
+func (w *inProcessContextWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// inProcessCopy sets to to a copy of from, passed as JSON.
+func inProcessCopy(from interface{}, to interface{}) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
+
+// inProcessStatus returns the status and the body of the error response that the HTTP handler
+// writes for err, and the error that a client returns for it, or nil if the status is one of the
+// expected ones of the resource.
+func inProcessStatus(err error, expected ...int) (int, []byte, error) {
+	e, ok := err.(*rdl.ResourceError)
+	if !ok {
+		e = &rdl.ResourceError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	var body []byte
+	if e.Code != http.StatusNoContent && e.Code != http.StatusNotModified {
+		b, _ := json.MarshalIndent(e, "", "  ")
+		body = append(b, '\n')
+	}
+	for _, code := range expected {
+		if e.Code == code {
+			return e.Code, body, nil
+		}
+	}
+	var errobj rdl.ResourceError
+	json.Unmarshal(body, &errobj)
+	if errobj.Code == 0 {
+		errobj.Code = e.Code
+	}
+	if errobj.Message == "" {
+		errobj.Message = string(body)
+	}
+	return e.Code, body, errobj
+}
+
+// inProcessHeader returns the headers of the error responses of the HTTP handler.
+func inProcessHeader() http.Header {
+	return http.Header{"Content-Type": []string{"application/json"}}
+}
+{{range methods}}{{.}}{{end}}`
+
+// GenerateGoInProcessClient generates a client that calls the handler of the Go server directly,
+// in <name>_inprocess.go next to the client. Both the client and the server must be generated into
+// the same package.
+func GenerateGoInProcessClient(opts *generateOptions) error {
+	schema := opts.schema
+	outdir := opts.dirName
+	if outdir == "" {
+		outdir = "."
+	} else if strings.HasSuffix(outdir, ".go") {
+		outdir = filepath.Dir(outdir)
+	}
+	path := filepath.Join(outdir, strings.ToLower(string(schema.Name))+"_inprocess.go")
+	out, file, _, err := outputWriter(path, "", ".go")
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		defer func() {
+			file.Close()
+			err := goFmt(path)
+			if err != nil {
+				fmt.Println("Warning: could not format go code:", err)
+			}
+		}()
+	}
+	gen := &inProcessClientGenerator{
+		registry:        rdl.NewTypeRegistry(schema),
+		schema:          schema,
+		name:            capitalize(string(schema.Name)),
+		banner:          opts.banner,
+		precise:         opts.preciseTypes,
+		ns:              opts.ns,
+		librdl:          opts.librdl,
+		requestResponse: opts.requestResponse,
+		clientFake:      opts.clientFake,
+	}
+	funcMap := template.FuncMap{
+		"rdlruntime": func() string { return gen.librdl },
+		"header":     func() string { return generationHeader(gen.banner) },
+		"package":    func() string { return generationPackage(gen.schema, gen.ns) },
+		"name":       func() string { return gen.name },
+		"cName":      func() string { return capitalize(gen.name) },
+		"client":     func() string { return gen.client() },
+		"adaptor":    func() string { return gen.name + "Adaptor" },
+		"request":    func() bool { return gen.requestResponse },
+		"paginated":  func() bool { return goPaginated(gen.registry, gen.schema, gen.precise) },
+		"api": func() string {
+			if gen.clientFake {
+				return gen.name + "API"
+			}
+			return ""
+		},
+		"methods": gen.methods,
+	}
+	t := template.Must(template.New("IN_PROCESS_CLIENT_TEMPLATE").Funcs(funcMap).Parse(inProcessClientTemplate))
+	if err := t.Execute(out, schema); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func (gen *inProcessClientGenerator) client() string {
+	return gen.name + "InProcessClient"
+}
+
+func (gen *inProcessClientGenerator) methods() []string {
+	var methods []string
+	for _, r := range gen.schema.Resources {
+		methods = append(methods, gen.method(r))
+		methods = append(methods, goPaginationMethods(gen.registry, r, gen.precise, gen.client(), gen.requestResponse))
+	}
+	return methods
+}
+
+// method returns the in-process client method of a resource, which calls the implementation with
+// the inputs of the call, and returns its results as the HTTP client would.
+func (gen *inProcessClientGenerator) method(r *rdl.Resource) string {
+	reg := gen.registry
+	name, _ := goMethodName(reg, r, gen.precise)
+	name = capitalize(name)
+	results := goMethodResults(reg, r, gen.precise)
+	m := (&reqRepClientGenerator{}).convertResource(reg, r, gen.precise)
+	contentType := goBodyContentType(reg, r, false)
+	//the expressions of the inputs of the call, by name, and the arguments of the implementation
+	inputs := make(map[string]string)
+	var args []string
+	copies := ""
+	for _, in := range r.Inputs {
+		if in.Context != "" {
+			continue
+		}
+		arg := goName(string(in.Name))
+		if gen.requestResponse {
+			arg = "req." + capitalize(goName(string(in.Name)))
+		}
+		inputs[string(in.Name)] = arg
+		if in.QueryParam == "" && !in.PathParam && in.Header == "" && contentType != "application/octet-stream" {
+			bodyArg := "arg" + capitalize(string(in.Name))
+			copies += "\tvar " + bodyArg + " " + gomodel.GoType(reg, in.Type, false, "", "", gen.precise, true) + "\n"
+			copies += "\tif err := inProcessCopy(" + arg + ", &" + bodyArg + "); err != nil {\n"
+			if gen.requestResponse {
+				copies += "\t\treturn nil, err\n"
+				copies += "\t}\n"
+				copies += "\tin." + capitalize(goName(string(in.Name))) + " = " + bodyArg + "\n"
+				continue
+			}
+			copies += "\t\treturn " + goInProcessZeros(results) + "err\n"
+			copies += "\t}\n"
+			arg = bodyArg
+		}
+		args = append(args, arg)
+	}
+	s := "\n"
+	ctx := "client.ctx"
+	if gen.requestResponse {
+		ctx = "ctx"
+		s += "func (client " + gen.client() + ") " + m.Name + "(ctx context.Context, req *" + m.RequestName + ") "
+		if m.Stream {
+			s += "(*" + goEventsName(r) + ", error) {\n"
+		} else {
+			s += "(*" + m.ResponseName + ", error) {\n"
+		}
+		s += "\tin := *req\n"
+		args = []string{"&in"}
+	} else {
+		s += "func (client " + gen.client() + ") " + goMethodSignature(reg, r, gen.precise) + " {\n"
+		if !streamResource(r) {
+			for i, t := range results[:len(results)-1] {
+				s += fmt.Sprintf("\tvar r%d %s\n", i, t)
+			}
+		}
+	}
+	s += copies
+	var pathParams []string
+	for _, in := range r.Inputs {
+		if in.PathParam {
+			pathParams = append(pathParams, fmt.Sprintf("%q: fmt.Sprint(%s)", in.Name, inputs[string(in.Name)]))
+		}
+	}
+	s += fmt.Sprintf("\trctx := client.resourceContext(%s, %q, %q, map[string]string{%s})\n", ctx, r.Method, r.Path, strings.Join(pathParams, ", "))
+	auth := ""
+	if r.Auth != nil {
+		if r.Auth.Authenticate {
+			auth = "\terr := client.authenticate(rctx)\n"
+		} else if r.Auth.Action != "" && r.Auth.Resource != "" {
+			arg := func(name string) string { return "string(" + inputs[name] + ")" }
+			auth = fmt.Sprintf("\terr := client.authorize(rctx, %q, %s, %s)\n", r.Auth.Action, goAuthStringWith(r.Auth.Resource, arg), goAuthStringWith(r.Auth.Domain, arg))
+		}
+	}
+	call := "client.adaptor.impl." + name + "(" + strings.Join(append([]string{"rctx"}, args...), ", ")
+	if streamResource(r) {
+		return s + gen.streamBody(r, auth, call) + "}\n"
+	}
+	if gen.requestResponse {
+		return s + gen.reqRepBody(r, m, auth, call) + "}\n"
+	}
+	return s + gen.plainBody(r, auth, call) + "}\n"
+}
+
+// expected returns the statuses that the client of a resource answers with its results, which
+// the implementation returns as an *rdl.ResourceError, and the code that sets its results for
+// them. The body of the error response is decoded as the value, but for 204 and 304.
+func (gen *inProcessClientGenerator) expected(r *rdl.Resource, data string, tags map[string]string) (string, string) {
+	codes := []string{rdl.StatusCode(r.Expected)}
+	for _, e := range r.Alternatives {
+		codes = append(codes, rdl.StatusCode(e))
+	}
+	s := ""
+	if data != "" {
+		s += "\t\tif status != 204 && status != 304 {\n"
+		s += "\t\t\tjson.Unmarshal(body, &" + data + ")\n"
+		s += "\t\t}\n"
+	}
+	//the handler only sets the ETag of a 304 response
+	for _, o := range r.Outputs {
+		if strings.ToLower(o.Header) == "etag" {
+			s += "\t\tif status == 304 {\n"
+			s += "\t\t\t" + tags[string(o.Name)] + "\n"
+			s += "\t\t}\n"
+			break
+		}
+	}
+	return ", " + strings.Join(codes, ", "), s
+}
+
+// exceptions returns the code that returns the body of an error response with a status declared by
+// the resource as its exception type, in a typed error.
+func (gen *inProcessClientGenerator) exceptions(r *rdl.Resource, ret string) string {
+	s := ""
+	for _, code := range sortedExceptionKeys(r.Exceptions) {
+		etype := r.Exceptions[code].Type
+		if t := gen.registry.FindType(rdl.TypeRef(etype)); etype == "ResourceError" || t == nil || gen.registry.BaseType(t) != rdl.BaseTypeStruct {
+			continue
+		}
+		s += "\t\tcase " + rdl.StatusCode(code) + ":\n"
+		s += "\t\t\tvar exception " + etype + "\n"
+		s += "\t\t\tif json.Unmarshal(body, &exception) == nil {\n"
+		s += "\t\t\t\t" + ret + "&" + etype + "Exception{Status: status, Header: inProcessHeader(), Body: &exception}\n"
+		s += "\t\t\t}\n"
+	}
+	if s == "" {
+		return ""
+	}
+	return "\t\tswitch status {\n" + s + "\t\t}\n"
+}
+
+func (gen *inProcessClientGenerator) plainBody(r *rdl.Resource, auth string, call string) string {
+	results := goMethodResults(gen.registry, r, gen.precise)
+	zeros := goInProcessZeros(results)
+	s := ""
+	if len(results) == 1 {
+		if auth == "" {
+			s += "\terr := " + call + ")\n"
+		} else {
+			s += auth + goInProcessCall("err", call)
+		}
+		s += "\tif err != nil {\n"
+		codes, _ := gen.expected(r, "", nil)
+		s += goInProcessStatus(codes, gen.exceptions(r, "return ")+"\t\treturn err\n")
+		s += "\t}\n"
+		s += "\treturn nil\n"
+		return s
+	}
+	var outs, vars, tagged []string
+	tags := make(map[string]string)
+	for i := range results[:len(results)-1] {
+		vars = append(vars, fmt.Sprintf("r%d", i))
+		if i == 0 {
+			outs = append(outs, "data")
+			continue
+		}
+		o := r.Outputs[i-1]
+		out := "out" + capitalize(string(o.Name))
+		outs = append(outs, out)
+		tagged = append(tagged, fmt.Sprintf("r%d = %s", i, out))
+		tags[string(o.Name)] = fmt.Sprintf("r%d = %s", i, out)
+	}
+	if auth == "" {
+		s += "\t" + strings.Join(outs, ", ") + ", err := " + call + ")\n"
+	} else {
+		for i, out := range outs {
+			s += "\tvar " + out + " " + results[i] + "\n"
+		}
+		s += auth + goInProcessCall(strings.Join(outs, ", ")+", err", call)
+	}
+	s += "\tif err != nil {\n"
+	codes, set := gen.expected(r, "r0", tags)
+	inner := gen.exceptions(r, "return "+zeros)
+	inner += "\t\tif err != nil {\n"
+	inner += "\t\t\treturn " + zeros + "err\n"
+	inner += "\t\t}\n"
+	inner += set
+	inner += "\t\treturn " + strings.Join(vars, ", ") + ", nil\n"
+	s += goInProcessStatus(codes, inner)
+	s += "\t}\n"
+	s += "\tif err := inProcessCopy(data, &r0); err != nil {\n"
+	s += "\t\treturn " + zeros + "err\n"
+	s += "\t}\n"
+	for _, t := range tagged {
+		s += "\t" + t + "\n"
+	}
+	s += "\treturn " + strings.Join(vars, ", ") + ", nil\n"
+	return s
+}
+
+func (gen *inProcessClientGenerator) reqRepBody(r *rdl.Resource, m *reqRepMethod, auth string, call string) string {
+	hasBody := !(r.Expected == "NO_CONTENT" && r.Alternatives == nil)
+	tags := make(map[string]string)
+	for _, o := range r.Outputs {
+		field := capitalize(goName(string(o.Name)))
+		tags[string(o.Name)] = "if resp != nil {\n\t\t\t\tresponse." + field + " = resp." + field + "\n\t\t\t}"
+	}
+	s := ""
+	if auth == "" {
+		s += "\tresp, err := " + call + ")\n"
+	} else {
+		s += "\tvar resp *" + m.ResponseName + "\n"
+		s += auth + goInProcessCall("resp, err", call)
+	}
+	s += "\tresponse := &" + m.ResponseName + "{}\n"
+	s += "\tif err != nil {\n"
+	data := ""
+	if hasBody {
+		data = "response.Body"
+	}
+	codes, set := gen.expected(r, data, tags)
+	inner := gen.exceptions(r, "return nil, ")
+	inner += "\t\tif err != nil {\n"
+	inner += "\t\t\treturn nil, err\n"
+	inner += "\t\t}\n"
+	inner += set
+	inner += "\t\treturn response, nil\n"
+	s += goInProcessStatus(codes, inner)
+	s += "\t}\n"
+	s += "\tif resp != nil {\n"
+	if hasBody {
+		s += "\t\tif err := inProcessCopy(resp.Body, &response.Body); err != nil {\n"
+		s += "\t\t\treturn nil, err\n"
+		s += "\t\t}\n"
+	}
+	for _, o := range r.Outputs {
+		field := capitalize(goName(string(o.Name)))
+		s += "\t\tresponse." + field + " = resp." + field + "\n"
+	}
+	s += "\t}\n"
+	s += "\treturn response, nil\n"
+	return s
+}
+
+// streamBody returns the code that runs the implementation of a stream resource, whose events
+// are read as those of an HTTP response, with the errors sent as events as by the HTTP handler.
+func (gen *inProcessClientGenerator) streamBody(r *rdl.Resource, auth string, call string) string {
+	gtype := gomodel.GoType(gen.registry, r.Type, false, "", "", gen.precise, true)
+	s := ""
+	if auth != "" {
+		s += auth
+		s += "\tif err != nil {\n"
+		s += "\t\t_, _, err := inProcessStatus(err)\n"
+		s += "\t\treturn nil, err\n"
+		s += "\t}\n"
+	}
+	s += "\tctx, cancel := context.WithCancel(rctx.Request.Context())\n"
+	s += "\trctx.Request = rctx.Request.WithContext(ctx)\n"
+	s += "\tbody, pipe := io.Pipe()\n"
+	s += "\twriter := &inProcessResponseWriter{header: make(http.Header), method: \"GET\", body: pipe, ready: make(chan struct{})}\n"
+	s += "\trctx.Writer = writer\n"
+	s += "\tgo func() {\n"
+	s += "\t\tdefer func() {\n"
+	s += "\t\t\tif r := recover(); r != nil {\n"
+	s += "\t\t\t\tpipe.CloseWithError(fmt.Errorf(\"in-process handler panic: %v\", r))\n"
+	s += "\t\t\t\treturn\n"
+	s += "\t\t\t}\n"
+	s += "\t\t\tpipe.Close()\n"
+	s += "\t\t}()\n"
+	s += "\t\tstream := newEventStream(writer, rctx.Request)\n"
+	s += "\t\terr := " + call + ", func(event " + gtype + ") error {\n"
+	s += "\t\t\treturn stream.send(\"\", event)\n"
+	s += "\t\t})\n"
+	s += "\t\tif err != nil && ctx.Err() == nil {\n"
+	s += "\t\t\te, ok := err.(*rdl.ResourceError)\n"
+	s += "\t\t\tif !ok {\n"
+	s += "\t\t\t\te = &rdl.ResourceError{Code: 500, Message: err.Error()}\n"
+	s += "\t\t\t}\n"
+	s += "\t\t\tstream.send(\"error\", e)\n"
+	s += "\t\t}\n"
+	s += "\t}()\n"
+	s += "\treturn &" + goEventsName(r) + "{body: &inProcessBody{PipeReader: body, cancel: cancel}, reader: bufio.NewReader(body)}, nil\n"
+	return s
+}
+
+// goInProcessCall returns the call of the implementation once the call is authorized, whose
+// results are assigned to vars.
+func goInProcessCall(vars string, call string) string {
+	return "\tif err == nil {\n\t\t" + vars + " = " + call + ")\n\t}\n"
+}
+
+// goInProcessStatus returns the call of inProcessStatus for the error of the implementation,
+// followed by the code that handles its results, which may not use them all.
+func goInProcessStatus(codes string, code string) string {
+	status, body := "status", "body"
+	if !strings.Contains(code, "status") {
+		status = "_"
+	}
+	if !strings.Contains(code, "body") {
+		body = "_"
+	}
+	return "\t\t" + status + ", " + body + ", err := inProcessStatus(err" + codes + ")\n" + code
+}
+
+// goInProcessZeros returns the zero values of the results of a client method other than the
+// error, each followed by a comma, i.e. "r0, r1, ".
+func goInProcessZeros(results []string) string {
+	s := ""
+	for i := range results[:len(results)-1] {
+		s += fmt.Sprintf("r%d, ", i)
+	}
+	return s
+}
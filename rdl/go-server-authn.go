@@ -0,0 +1,340 @@
+// Copyright 2015 Yahoo Inc.
+// Licensed under the terms of the Apache version 2.0 license. See LICENSE file for terms.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const serverAuthenticatorsTemplate = `{{header}}
+
+package {{package}}
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	rdl "{{rdlruntime}}"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//
+// TokenPrincipal is the rdl.Principal of a request authenticated by a JWTAuthenticator or a
+// BasicAuthenticator. Claims are the claims of the JWT, and are nil for HTTP Basic.
+//
+type TokenPrincipal struct {
+	Domain      string
+	Name        string
+	Credentials string
+	Claims      map[string]interface{}
+}
+
+func (p *TokenPrincipal) GetDomain() string {
+	return p.Domain
+}
+
+func (p *TokenPrincipal) GetName() string {
+	return p.Name
+}
+
+func (p *TokenPrincipal) GetYRN() string {
+	if p.Domain == "" {
+		return p.Name
+	}
+	return p.Domain + "." + p.Name
+}
+
+func (p *TokenPrincipal) GetCredentials() string {
+	return p.Credentials
+}
+
+func (p *TokenPrincipal) GetHTTPHeaderName() string {
+	return "Authorization"
+}
+
+//
+// JWTAuthenticator is an rdl.Authenticator of "Authorization: Bearer" JSON Web Tokens, signed
+// with an HMAC (HS256, HS384, HS512) or RSA (RS256, RS384, RS512) key. The principal is named by
+// the sub claim, in the domain given by the DomainClaim claim, or in Domain if the token has none.
+// Tokens must not be expired, and must match Issuer and Audience if they are set.
+//
+type JWTAuthenticator struct {
+	Domain      string
+	DomainClaim string
+	Issuer      string
+	Audience    string
+
+	// Leeway is the clock skew allowed when checking the exp and nbf claims.
+	Leeway time.Duration
+
+	hmacKey []byte
+	rsaKey  *rsa.PublicKey
+}
+
+// NewJWTAuthenticator returns a JWTAuthenticator with the key in the file at path: a PEM encoded
+// RSA public key or certificate, or else an HMAC secret. Principals are in domain unless the
+// tokens have a domain claim.
+func NewJWTAuthenticator(path string, domain string) (*JWTAuthenticator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	authn := &JWTAuthenticator{Domain: domain, DomainClaim: "domain", Leeway: time.Minute}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		authn.hmacKey = bytes.TrimSpace(data)
+		if len(authn.hmacKey) == 0 {
+			return nil, fmt.Errorf("%s: empty key", path)
+		}
+		return authn, nil
+	}
+	var key interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+	authn.rsaKey = rsaKey
+	return authn, nil
+}
+
+func (authn *JWTAuthenticator) HTTPHeader() string {
+	return "Authorization"
+}
+
+func (authn *JWTAuthenticator) Authenticate(creds string) rdl.Principal {
+	if len(creds) < 7 || !strings.EqualFold(creds[:7], "Bearer ") {
+		return nil
+	}
+	token := strings.TrimSpace(creds[7:])
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	var header struct {
+		Alg string ` + "`" + `json:"alg"` + "`" + `
+	}
+	var claims map[string]interface{}
+	if !decodeJWTPart(parts[0], &header) || !decodeJWTPart(parts[1], &claims) {
+		return nil
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !authn.verify(header.Alg, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(authn.Leeway)) {
+		return nil
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(authn.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil
+	}
+	if authn.Issuer != "" && claims["iss"] != authn.Issuer {
+		return nil
+	}
+	if authn.Audience != "" {
+		found := claims["aud"] == authn.Audience
+		if auds, ok := claims["aud"].([]interface{}); ok {
+			for _, aud := range auds {
+				found = found || aud == authn.Audience
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	name, _ := claims["sub"].(string)
+	if name == "" {
+		return nil
+	}
+	domain := authn.Domain
+	if d, ok := claims[authn.DomainClaim].(string); ok && authn.DomainClaim != "" {
+		domain = d
+	}
+	return &TokenPrincipal{Domain: domain, Name: name, Credentials: token, Claims: claims}
+}
+
+func (authn *JWTAuthenticator) verify(alg string, signed []byte, signature []byte) bool {
+	if len(alg) != 5 {
+		return false
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+	switch alg[:2] {
+	case "HS":
+		if authn.hmacKey == nil {
+			return false
+		}
+		mac := hmac.New(hash.New, authn.hmacKey)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS":
+		if authn.rsaKey == nil {
+			return false
+		}
+		h := hash.New()
+		h.Write(signed)
+		return rsa.VerifyPKCS1v15(authn.rsaKey, hash, h.Sum(nil), signature) == nil
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) bool {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+//
+// BasicAuthenticator is an rdl.Authenticator of "Authorization: Basic" credentials, checked
+// against a file of "name:password" lines. Passwords should be bcrypt hashes, i.e. "$2y$..." as
+// written by htpasswd -B. "{SHA256}" or "{SHA}" (as written by htpasswd -s) followed by the base64
+// encoded hash of the password, which is unsalted, and "{PLAIN}" followed by the password itself
+// are only meant for tests. Blank lines and lines starting with # are ignored.
+//
+type BasicAuthenticator struct {
+	Domain    string
+	passwords map[string]string
+}
+
+// NewBasicAuthenticator returns a BasicAuthenticator of the users in the file at path, whose
+// principals are in domain.
+func NewBasicAuthenticator(path string, domain string) (*BasicAuthenticator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	authn := &BasicAuthenticator{Domain: domain, passwords: make(map[string]string)}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		j := strings.Index(line, ":")
+		if j <= 0 || !(strings.HasPrefix(line[j+1:], "{") || strings.HasPrefix(line[j+1:], "$2")) {
+			return nil, fmt.Errorf("%s:%d: expected name:$2y$bcrypt or name:{SCHEME}password", path, i+1)
+		}
+		authn.passwords[line[:j]] = line[j+1:]
+	}
+	return authn, nil
+}
+
+func (authn *BasicAuthenticator) HTTPHeader() string {
+	return "Authorization"
+}
+
+func (authn *BasicAuthenticator) Authenticate(creds string) rdl.Principal {
+	if len(creds) < 6 || !strings.EqualFold(creds[:6], "Basic ") {
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(creds[6:]))
+	if err != nil {
+		return nil
+	}
+	name, password, ok := strings.Cut(string(decoded), ":")
+	stored, found := authn.passwords[name]
+	if !ok || !found {
+		return nil
+	}
+	var expected string
+	switch {
+	case strings.HasPrefix(stored, "$2"):
+		if bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) != nil {
+			return nil
+		}
+		return &TokenPrincipal{Domain: authn.Domain, Name: name}
+	case strings.HasPrefix(stored, "{SHA256}"):
+		sum := sha256.Sum256([]byte(password))
+		stored, expected = stored[8:], base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(stored, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		stored, expected = stored[5:], base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(stored, "{PLAIN}"):
+		stored, expected = stored[7:], password
+	default:
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(expected)) != 1 {
+		return nil
+	}
+	return &TokenPrincipal{Domain: authn.Domain, Name: name}
+}
+`
+
+// GenerateGoServerAuthenticators generates the JWT and HTTP Basic authenticators to pass to the
+// Init of the Go server, in <name>_authn.go next to the server. They are kept out of the server
+// so that only the servers that use them depend on golang.org/x/crypto.
+func GenerateGoServerAuthenticators(opts *generateOptions) error {
+	schema := opts.schema
+	outdir := opts.dirName
+	if outdir == "" {
+		outdir = "."
+	} else if strings.HasSuffix(outdir, ".go") {
+		outdir = filepath.Dir(outdir)
+	}
+	path := filepath.Join(outdir, strings.ToLower(string(schema.Name))+"_authn.go")
+	out, file, _, err := outputWriter(path, "", ".go")
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		defer func() {
+			file.Close()
+			err := goFmt(path)
+			if err != nil {
+				fmt.Println("Warning: could not format go code:", err)
+			}
+		}()
+	}
+	funcMap := template.FuncMap{
+		"rdlruntime": func() string { return opts.librdl },
+		"header":     func() string { return generationHeader(opts.banner) },
+		"package":    func() string { return generationPackage(schema, opts.ns) },
+	}
+	t := template.Must(template.New("SERVER_AUTHENTICATORS_TEMPLATE").Funcs(funcMap).Parse(serverAuthenticatorsTemplate))
+	if err := t.Execute(out, schema); err != nil {
+		return err
+	}
+	return out.Flush()
+}
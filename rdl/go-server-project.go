@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -47,11 +48,19 @@ func GenerateGoServerProject(opts *generateOptions) error {
 	}
 	implpath := filepath.Join(gendir, name+".go")
 	if !fileExists(implpath) {
-		err = GenerateGoDaemonImpl(opts.banner, schema, gendir, opts.ns, opts.librdl, opts.prefixEnums, opts.preciseTypes, opts.untaggedUnions)
+		err = GenerateGoDaemonImpl(opts.banner, schema, gendir, opts.ns, opts.librdl, opts.prefixEnums, opts.preciseTypes, opts.untaggedUnions, opts.requestResponse)
 		if err != nil {
 			return err
 		}
 	}
+	err = GenerateGoFileAuthorizer(opts.banner, schema, gendir, opts.librdl)
+	if err != nil {
+		return err
+	}
+	err = GenerateGoClientBenchmark(opts.banner, schema, gendir, opts.preciseTypes, opts.requestResponse)
+	if err != nil {
+		return err
+	}
 
 	cmddir := filepath.Join(outdir, "cmd")
 	daemondir := filepath.Join(cmddir, name+"d")
@@ -136,6 +145,14 @@ func GenerateGoDaemonMain(banner string, schema *rdl.Schema, outdir string, ns s
 		"comment":     commentFun,
 		"method_sig":  func(r *rdl.Resource) string { return goMethodSignatureImpl(registry, r, preciseTypes) },
 		"method_body": func(r *rdl.Resource) string { return goMethodBodyImpl(registry, r, preciseTypes) },
+		"events": func() bool {
+			for _, r := range schema.Resources {
+				if streamResource(r) {
+					return true
+				}
+			}
+			return false
+		},
 	}
 	t := template.Must(template.New("FOO").Funcs(funcMap).Parse(serverMainTemplate))
 	err = t.Execute(out, schema)
@@ -147,6 +164,9 @@ func GenerateGoDaemonMain(banner string, schema *rdl.Schema, outdir string, ns s
 }
 
 func goMethodSignatureImpl(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
+	if streamResource(r) {
+		return goServerMethodSignature(reg, r, precise)
+	}
 	noContent := r.Expected == "NO_CONTENT" && r.Alternatives == nil
 	returnSpec := "error"
 	//fixme: no content *with* output headers
@@ -180,7 +200,38 @@ func goMethodBodyImpl(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) strin
 		slots = slots + "%v"
 	}
 	s := "\tfmt.Printf(\"" + methName + "(" + slots + ")\\n\", " + strings.Join(args, ", ") + ")\n"
-	if noContent {
+	if noContent || streamResource(r) {
+		return s + "\treturn &rdl.ResourceError{Code: 501, Message: \"Not Implemented\"}"
+	}
+	zeros := []string{goZeroValue(reg, r.Type, precise)}
+	for _, o := range r.Outputs {
+		zeros = append(zeros, goZeroValue(reg, o.Type, precise))
+	}
+	return s + "\treturn " + strings.Join(zeros, ", ") + ", &rdl.ResourceError{Code: 501, Message: \"Not Implemented\"}"
+}
+
+// goZeroValue returns the zero value of the Go type that a result of the given RDL type is
+// returned as.
+func goZeroValue(reg rdl.TypeRegistry, t rdl.TypeRef, precise bool) string {
+	gtype := gomodel.GoType2(reg, t, false, "", "", precise, true, "")
+	if strings.HasPrefix(gtype, "*") || strings.HasPrefix(gtype, "[]") || strings.HasPrefix(gtype, "map[") {
+		return "nil"
+	}
+	switch reg.FindBaseType(t) {
+	case rdl.BaseTypeString, rdl.BaseTypeSymbol:
+		return "\"\""
+	case rdl.BaseTypeBool:
+		return "false"
+	case rdl.BaseTypeInt8, rdl.BaseTypeInt16, rdl.BaseTypeInt32, rdl.BaseTypeInt64, rdl.BaseTypeFloat32, rdl.BaseTypeFloat64:
+		return "0"
+	}
+	return "nil"
+}
+
+func goReqRepMethodBodyImpl(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
+	m := (&reqRepClientGenerator{}).convertResource(reg, r, precise)
+	s := "\tfmt.Printf(\"" + m.Name + "(%+v)\\n\", *req)\n"
+	if streamResource(r) {
 		return s + "\treturn &rdl.ResourceError{Code: 501, Message: \"Not Implemented\"}"
 	}
 	return s + "\treturn nil, &rdl.ResourceError{Code: 501, Message: \"Not Implemented\"}"
@@ -191,21 +242,45 @@ var serverMainTemplate = `{{header}}
 package main
 
 import (
+	"flag"
+	"log"
 	"net/http"
+	"time"
 
+	rdl "{{rdlruntime}}"
 	{{package}} "{{module}}"
 )
 
 func main() {
+	policy := flag.String("policy", "", "the JSON or YAML authorization policy file, instead of the Authorize method of the implementation")
+	flag.Parse()
 	endpoint := "localhost:4080"
 	url := "http://" + endpoint + "/{{package}}"
 	impl := new({{package}}.{{impl}})
-	handler := {{package}}.Init(impl, url, impl)
-	http.ListenAndServe(endpoint, handler)
+	var authz rdl.Authorizer = impl
+	if *policy != "" {
+		fileAuthz, err := {{package}}.NewFileAuthorizer(*policy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authz = fileAuthz
+	}
+	handler := {{package}}.Init(impl, url, authz)
+	server := &http.Server{
+		Addr:              endpoint,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       60 * time.Second,{{if events}}
+		// no WriteTimeout, which would end the event streams{{else}}
+		WriteTimeout:      60 * time.Second,{{end}}
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+	}
+	log.Fatal(server.ListenAndServe())
 }
 `
 
-func GenerateGoDaemonImpl(banner string, schema *rdl.Schema, outdir string, ns string, librdl string, prefixEnums bool, preciseTypes bool, untaggedUnions []string) error {
+func GenerateGoDaemonImpl(banner string, schema *rdl.Schema, outdir string, ns string, librdl string, prefixEnums bool, preciseTypes bool, untaggedUnions []string, requestResponse bool) error {
 	name := strings.ToLower(string(schema.Name))
 	filepath := outdir + "/" + name + ".go"
 	out, file, _, err := outputWriter(filepath, "", ".go")
@@ -252,16 +327,47 @@ func GenerateGoDaemonImpl(banner string, schema *rdl.Schema, outdir string, ns s
 				return ns
 			}
 		},
-		"rdlruntime":  func() string { return librdl },
-		"header":      func() string { return generationHeader(banner) },
-		"package":     func() string { return generationPackage(schema, "") },
-		"field":       fieldFun,
-		"flattened":   func(t *rdl.Type) []*rdl.StructFieldDef { return flattenedFields(registry, t) },
-		"typeRef":     func(t *rdl.Type) string { return makeTypeRef(registry, t, preciseTypes) },
-		"basename":    basenameFunc,
-		"comment":     commentFun,
-		"method_sig":  func(r *rdl.Resource) string { return goMethodSignatureImpl(registry, r, preciseTypes) },
-		"method_body": func(r *rdl.Resource) string { return goMethodBodyImpl(registry, r, preciseTypes) },
+		"rdlruntime": func() string { return librdl },
+		"header":     func() string { return generationHeader(banner) },
+		"package":    func() string { return generationPackage(schema, "") },
+		"field":      fieldFun,
+		"flattened":  func(t *rdl.Type) []*rdl.StructFieldDef { return flattenedFields(registry, t) },
+		"typeRef":    func(t *rdl.Type) string { return makeTypeRef(registry, t, preciseTypes) },
+		"basename":   basenameFunc,
+		"comment":    commentFun,
+		"domains": func() bool {
+			for _, r := range schema.Resources {
+				if r.Auth != nil && r.Auth.Domain != "" {
+					return true
+				}
+			}
+			return false
+		},
+		"is_patch":         func(r *rdl.Resource) bool { return goPatchTypes(registry, r, false) != "" },
+		"patch_target_sig": func(r *rdl.Resource) string { return goPatchTargetSignature(registry, r, preciseTypes) },
+		"streams": func() bool {
+			if requestResponse {
+				return false
+			}
+			for _, r := range schema.Resources {
+				if goBodyContentType(registry, r, false) == "application/octet-stream" {
+					return true
+				}
+			}
+			return false
+		},
+		"method_sig": func(r *rdl.Resource) string {
+			if requestResponse {
+				return goReqRepServerMethodSignature(registry, r, preciseTypes)
+			}
+			return goMethodSignatureImpl(registry, r, preciseTypes)
+		},
+		"method_body": func(r *rdl.Resource) string {
+			if requestResponse {
+				return goReqRepMethodBodyImpl(registry, r, preciseTypes)
+			}
+			return goMethodBodyImpl(registry, r, preciseTypes)
+		},
 	}
 	t := template.Must(template.New("FOO").Funcs(funcMap).Parse(serverImplTemplate))
 	err = t.Execute(out, schema)
@@ -276,7 +382,8 @@ var serverImplTemplate = `{{header}}
 package {{package}}
 
 import(
-	"fmt"
+	"fmt"{{if streams}}
+	"io"{{end}}
 
 	rdl "{{rdlruntime}}"
 )
@@ -286,7 +393,11 @@ type {{impl}} struct{}
 func (impl {{impl}}) {{method_sig .}} {
 {{method_body .}}
 }
-{{end}}
+{{if is_patch .}}
+func (impl {{impl}}) {{patch_target_sig .}} {
+	return nil, &rdl.ResourceError{Code: 501, Message: "Not Implemented"}
+}
+{{end}}{{end}}
 //Authenticate - required by the framework. If returning true, you should set context.Principal to a valid object
 func (impl *{{impl}}) Authenticate(context *rdl.ResourceContext) bool {
 	return false
@@ -296,6 +407,939 @@ func (impl *{{impl}}) Authenticate(context *rdl.ResourceContext) bool {
 func (impl *{{impl}}) Authorize(action string, resource string, principal rdl.Principal) (bool, error) {
 	return true, nil
 }
+{{if domains}}
+//AuthorizeDomain - called instead of Authorize for the resources that are authorized in a domain.
+func (impl *{{impl}}) AuthorizeDomain(action string, resource string, domain string, principal rdl.Principal) (bool, error) {
+	return true, nil
+}
+{{end}}`
+
+// GenerateGoFileAuthorizer generates the FileAuthorizer of a project, and its tests, which check
+// the authorize statements of the schema against policies that allow them. Like the implementation,
+// they are only generated if their files do not exist yet, so that they can be edited.
+func GenerateGoFileAuthorizer(banner string, schema *rdl.Schema, outdir string, librdl string) error {
+	name := strings.ToLower(string(schema.Name))
+	funcMap := template.FuncMap{
+		"rdlruntime": func() string { return librdl },
+		"header":     func() string { return generationHeader(banner) },
+		"package":    func() string { return generationPackage(schema, "") },
+		"authorizeSpecs": func() string {
+			s := ""
+			for _, r := range schema.Resources {
+				if r.Auth == nil || r.Auth.Action == "" || r.Auth.Resource == "" {
+					continue
+				}
+				methName, _ := goMethodName(rdl.NewTypeRegistry(schema), r, false)
+				s += fmt.Sprintf("\n\t{%q, %q, %q, %q, %q, %q},", capitalize(methName), r.Auth.Action,
+					authorizeSpecPattern(r.Auth.Resource, "*"), authorizeSpecPattern(r.Auth.Resource, "example"),
+					authorizeSpecPattern(r.Auth.Domain, "*"), authorizeSpecPattern(r.Auth.Domain, "example"))
+			}
+			return s
+		},
+	}
+	for _, f := range []struct{ path, tmpl string }{
+		{filepath.Join(outdir, name+"_authorizer.go"), fileAuthorizerTemplate},
+		{filepath.Join(outdir, name+"_authorizer_test.go"), fileAuthorizerTestTemplate},
+	} {
+		if fileExists(f.path) {
+			continue
+		}
+		out, file, _, err := outputWriter(f.path, "", ".go")
+		if err != nil {
+			return err
+		}
+		t := template.Must(template.New(name).Funcs(funcMap).Parse(f.tmpl))
+		err = t.Execute(out, schema)
+		out.Flush()
+		if file != nil {
+			file.Close()
+			if err == nil && goFmt(f.path) != nil {
+				fmt.Println("Warning: could not format go code:", f.path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateGoClientBenchmark generates the benchmarks of the client of a project, which call the
+// first GET resource of the schema that responds with 200 on a local server that answers all
+// calls with an example of its result, with a client that shares one http.Client across calls,
+// and with one that builds an http.Client per call. Schemas without such a resource get no
+// benchmarks. Like the implementation, they are only generated if their file does not exist yet.
+func GenerateGoClientBenchmark(banner string, schema *rdl.Schema, outdir string, precise bool, requestResponse bool) error {
+	reg := rdl.NewTypeRegistry(schema)
+	bench := goBenchmarkCall(reg, schema, precise, requestResponse)
+	if bench == nil {
+		return nil
+	}
+	name := strings.ToLower(string(schema.Name))
+	funcMap := template.FuncMap{
+		"header":  func() string { return generationHeader(banner) },
+		"package": func() string { return generationPackage(schema, "") },
+		"client":  func() string { return capitalize(string(schema.Name)) + "Client" },
+		"bench":   func() *goBenchmark { return bench },
+		"reqrep":  func() bool { return requestResponse },
+	}
+	path := filepath.Join(outdir, name+"_client_test.go")
+	if fileExists(path) {
+		return nil
+	}
+	out, file, _, err := outputWriter(path, "", ".go")
+	if err != nil {
+		return err
+	}
+	t := template.Must(template.New(name).Funcs(funcMap).Parse(clientBenchmarkTemplate))
+	err = t.Execute(out, schema)
+	out.Flush()
+	if file != nil {
+		file.Close()
+		if err == nil && goFmt(path) != nil {
+			fmt.Println("Warning: could not format go code:", path)
+		}
+	}
+	return err
+}
+
+// goBenchmark is the call of the generated client benchmarks, and the JSON body of its response.
+type goBenchmark struct {
+	Call    string
+	Results string
+	Body    string
+}
+
+// goBenchmarkCall returns the call of the client method of the first GET resource that is not a
+// stream, responds with 200, and has an example of its result type, with zero values for its
+// arguments, other than "bench" for its string path parameters, or nil.
+func goBenchmarkCall(reg rdl.TypeRegistry, schema *rdl.Schema, precise bool, requestResponse bool) *goBenchmark {
+	var r *rdl.Resource
+	var body []byte
+	for _, res := range schema.Resources {
+		if res.Method != "GET" || streamResource(res) || !goRespondsOK(res) {
+			continue
+		}
+		example := goExampleValue(reg, res.Type)
+		if example == nil || !rdl.Validate(schema, string(res.Type), example).Valid {
+			continue
+		}
+		if b, err := json.Marshal(example); err == nil {
+			r, body = res, b
+			break
+		}
+	}
+	if r == nil {
+		return nil
+	}
+	results := goMethodResults(reg, r, precise)
+	bench := &goBenchmark{Body: string(body)}
+	value := func(in *rdl.ResourceInput) string {
+		if in.PathParam && reg.BaseTypeName(in.Type) == "String" {
+			return "\"bench\""
+		}
+		gtype := gomodel.GoType2(reg, in.Type, in.Optional, "", "", precise, true, "")
+		if strings.HasPrefix(gtype, "*") {
+			return "nil"
+		}
+		return goZeroValue(reg, in.Type, precise)
+	}
+	if requestResponse {
+		m := (&reqRepClientGenerator{}).convertResource(reg, r, precise)
+		var fields []string
+		for _, in := range r.Inputs {
+			if in.PathParam && reg.BaseTypeName(in.Type) == "String" {
+				fields = append(fields, capitalize(goName(string(in.Name)))+": "+value(in))
+			}
+		}
+		bench.Call = m.Name + "(context.Background(), &" + m.RequestName + "{" + strings.Join(fields, ", ") + "})"
+		bench.Results = "_, err"
+		return bench
+	}
+	methName, _ := goMethodName(reg, r, precise)
+	var args []string
+	for _, in := range r.Inputs {
+		if in.Context == "" {
+			args = append(args, value(in))
+		}
+	}
+	bench.Call = capitalize(methName) + "(" + strings.Join(args, ", ") + ")"
+	bench.Results = strings.Repeat("_, ", len(results)-1) + "err"
+	return bench
+}
+
+// goExampleValue returns a value of the type, as decoded from JSON, with "bench" for its strings
+// and the first symbol of its enums, and only the required fields of its structs, or nil if the
+// type has no such value. The value may still not be valid, i.e. if a pattern rejects "bench".
+func goExampleValue(reg rdl.TypeRegistry, name rdl.TypeRef) interface{} {
+	t := reg.FindType(name)
+	if t == nil {
+		return nil
+	}
+	switch reg.BaseType(t) {
+	case rdl.BaseTypeStruct:
+		obj := make(map[string]interface{})
+		for _, f := range flattenedFields(reg, t) {
+			if f.Optional {
+				continue
+			}
+			value := goExampleValue(reg, f.Type)
+			if value == nil {
+				return nil
+			}
+			obj[string(f.Name)] = value
+		}
+		return obj
+	case rdl.BaseTypeArray:
+		return []interface{}{}
+	case rdl.BaseTypeMap:
+		return map[string]interface{}{}
+	case rdl.BaseTypeEnum:
+		if t.EnumTypeDef != nil && len(t.EnumTypeDef.Elements) > 0 {
+			return string(t.EnumTypeDef.Elements[0].Symbol)
+		}
+	case rdl.BaseTypeString, rdl.BaseTypeSymbol:
+		return "bench"
+	case rdl.BaseTypeBool:
+		return false
+	case rdl.BaseTypeInt8, rdl.BaseTypeInt16, rdl.BaseTypeInt32, rdl.BaseTypeInt64, rdl.BaseTypeFloat32, rdl.BaseTypeFloat64:
+		return float64(0)
+	case rdl.BaseTypeTimestamp:
+		return "1970-01-01T00:00:00.000Z"
+	}
+	return nil
+}
+
+// goRespondsOK returns true if 200 is an expected status of the resource.
+func goRespondsOK(r *rdl.Resource) bool {
+	if r.Expected == "OK" || r.Expected == "" {
+		return true
+	}
+	for _, e := range r.Alternatives {
+		if e == "OK" {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeSpecPattern replaces the {var} references of a resource or domain string of an
+// authorize statement with the given value.
+func authorizeSpecPattern(spec string, value string) string {
+	i := strings.Index(spec, "{")
+	for i >= 0 {
+		j := strings.Index(spec[i:], "}")
+		if j < 0 {
+			break
+		}
+		spec = spec[0:i] + value + spec[i+j+1:]
+		i = strings.Index(spec, "{")
+	}
+	return spec
+}
+
+var fileAuthorizerTemplate = `{{header}}
+
+package {{package}}
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rdl "{{rdlruntime}}"
+)
+
+//
+// FileAuthorizer is an rdl.Authorizer that enforces a role based access control policy read
+// from a JSON or YAML file, which is reloaded when it changes. The policy maps principals, and
+// groups of principals, to the actions and resources they are allowed or denied, i.e.
+//
+//	{
+//	  "groups": {"admins": ["users.alice", "users.bob"]},
+//	  "rules": [
+//	    {"principals": ["group:admins"], "actions": ["*"], "resources": ["*"]},
+//	    {"principals": ["*"], "actions": ["read"], "resources": ["item.*"]},
+//	    {"principals": ["users.mallory"], "actions": ["*"], "resources": ["*"], "effect": "deny"}
+//	  ]
+//	}
+//
+// Principals are named "domain.name". The * wildcard in a pattern matches any sequence of
+// characters. A rule with domains only applies to resources authorized in a matching domain.
+// A request is allowed if a rule allows it and no rule denies it.
+//
+// The same policy in YAML is
+//
+//	groups:
+//	  admins: [users.alice, users.bob]
+//	rules:
+//	  - principals: ["group:admins"]
+//	    actions: ["*"]
+//	    resources: ["*"]
+//	  - principals: ["*"]
+//	    actions: [read]
+//	    resources: ["item.*"]
+//	  - principals: [users.mallory]
+//	    actions: ["*"]
+//	    resources: ["*"]
+//	    effect: deny
+//
+// A file whose content starts with { is decoded as JSON, and any other as YAML, of which the
+// subset parsed by parseYAML is supported.
+//
+type FileAuthorizer struct {
+	// ReloadInterval is the minimum time between checks of the file for changes.
+	ReloadInterval time.Duration
+
+	path    string
+	mu      sync.Mutex
+	policy  *AuthorizationPolicy
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// AuthorizationPolicy is the content of the policy file of a FileAuthorizer.
+type AuthorizationPolicy struct {
+	Groups map[string][]string ` + "`" + `json:"groups,omitempty"` + "`" + `
+	Rules  []AuthorizationRule  ` + "`" + `json:"rules"` + "`" + `
+}
+
+// AuthorizationRule allows, or denies if its Effect is "deny", the actions on the resources to the
+// principals. Principals are patterns, or "group:" followed by the name of a group.
+type AuthorizationRule struct {
+	Principals []string ` + "`" + `json:"principals"` + "`" + `
+	Actions    []string ` + "`" + `json:"actions"` + "`" + `
+	Resources  []string ` + "`" + `json:"resources"` + "`" + `
+	Domains    []string ` + "`" + `json:"domains,omitempty"` + "`" + `
+	Effect     string   ` + "`" + `json:"effect,omitempty"` + "`" + `
+}
+
+// NewFileAuthorizer returns a FileAuthorizer for the policy file at path, which must be valid.
+func NewFileAuthorizer(path string) (*FileAuthorizer, error) {
+	authz := &FileAuthorizer{ReloadInterval: time.Second, path: path, checked: time.Now()}
+	if err := authz.load(); err != nil {
+		return nil, err
+	}
+	return authz, nil
+}
+
+func (authz *FileAuthorizer) Authorize(action string, resource string, principal rdl.Principal) (bool, error) {
+	return authz.AuthorizeDomain(action, resource, "", principal)
+}
+
+func (authz *FileAuthorizer) AuthorizeDomain(action string, resource string, domain string, principal rdl.Principal) (bool, error) {
+	if principal == nil {
+		return false, nil
+	}
+	name := principal.GetName()
+	if principal.GetDomain() != "" {
+		name = principal.GetDomain() + "." + name
+	}
+	policy := authz.current()
+	allowed := false
+	for _, rule := range policy.Rules {
+		if rule.matches(policy, name, action, resource, domain) {
+			if rule.Effect == "deny" {
+				return false, nil
+			}
+			allowed = true
+		}
+	}
+	return allowed, nil
+}
+
+// current returns the policy, after reloading the file if it has changed. A file that cannot be
+// loaded is logged, and the previous policy kept.
+func (authz *FileAuthorizer) current() *AuthorizationPolicy {
+	authz.mu.Lock()
+	defer authz.mu.Unlock()
+	if now := time.Now(); now.Sub(authz.checked) >= authz.ReloadInterval {
+		authz.checked = now
+		info, err := os.Stat(authz.path)
+		if err == nil && (!info.ModTime().Equal(authz.modTime) || info.Size() != authz.size) {
+			err = authz.load()
+		}
+		if err != nil {
+			log.Println("*** Cannot reload the authorization policy:", err)
+		}
+	}
+	return authz.policy
+}
+
+func (authz *FileAuthorizer) load() error {
+	info, err := os.Stat(authz.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(authz.path)
+	if err != nil {
+		return err
+	}
+	var policy AuthorizationPolicy
+	if err := decodePolicy(data, &policy); err != nil {
+		return fmt.Errorf("%s: %v", authz.path, err)
+	}
+	for i, rule := range policy.Rules {
+		if rule.Effect != "" && rule.Effect != "allow" && rule.Effect != "deny" {
+			return fmt.Errorf("%s: bad effect '%s' in rule %d", authz.path, rule.Effect, i)
+		}
+	}
+	authz.policy, authz.modTime, authz.size = &policy, info.ModTime(), info.Size()
+	return nil
+}
+
+func (rule *AuthorizationRule) matches(policy *AuthorizationPolicy, principal string, action string, resource string, domain string) bool {
+	if len(rule.Domains) > 0 && (domain == "" || !matchAny(rule.Domains, domain)) {
+		return false
+	}
+	if !matchAny(rule.Actions, action) || !matchAny(rule.Resources, resource) {
+		return false
+	}
+	for _, p := range rule.Principals {
+		if strings.HasPrefix(p, "group:") {
+			if matchAny(policy.Groups[p[6:]], principal) {
+				return true
+			}
+		} else if matchWildcard(p, principal) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if matchWildcard(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard matches s against a pattern in which * matches any sequence of characters.
+func matchWildcard(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// decodePolicy decodes a policy from JSON if it starts with {, and otherwise from YAML, into
+// the JSON fields of the policy.
+func decodePolicy(data []byte, policy *AuthorizationPolicy) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return json.Unmarshal(data, policy)
+	}
+	doc, err := parseYAML(data)
+	if err != nil {
+		return err
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, policy)
+}
+
+// yamlLine is a line of a YAML document, without its indentation and comment.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+//
+// parseYAML parses the subset of YAML that policies are written in: block mappings and
+// sequences, indented with spaces, flow sequences and mappings, and plain, single quoted and
+// double quoted scalars, which are all strings. Empty values are null. Anchors, aliases, tags,
+// multi-line scalars and multiple documents are not supported.
+//
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || (text == "---" && len(p.lines) == 0) {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: indentation with a tab", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(line) - len(text), text: text})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	doc, err := p.parseBlock(p.lines[0].indent)
+	if err == nil && p.pos < len(p.lines) {
+		err = p.errorf("unexpected line")
+	}
+	return doc, err
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.lines[p.pos].number, fmt.Sprintf(format, args...))
+}
+
+// parseBlock parses the block sequence or mapping whose lines are at the indent.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	//a sequence below a key at the same indent ends at the next key
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		item := strings.TrimLeft(line.text[1:], " ")
+		var value interface{}
+		var err error
+		switch {
+		case item == "":
+			p.pos++
+			value, err = p.parseNested(indent, false)
+		case isYAMLItem(item) || yamlKeyEnd(item) >= 0:
+			//the item is a block at the column of its content, which continues on the lines below
+			p.lines[p.pos] = yamlLine{number: line.number, indent: indent + len(line.text) - len(item), text: item}
+			value, err = p.parseBlock(p.lines[p.pos].indent)
+		default:
+			value, err = parseYAMLValue(item)
+			if err != nil {
+				err = p.errorf("%v", err)
+			}
+			p.pos++
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, value)
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		end := yamlKeyEnd(line.text)
+		if end < 0 {
+			return nil, p.errorf("expected a key and a colon")
+		}
+		key, err := parseYAMLValue(line.text[:end])
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, p.errorf("the key is not a string")
+		}
+		if _, ok := m[name]; ok {
+			return nil, p.errorf("duplicate key %q", name)
+		}
+		var value interface{}
+		if rest := strings.TrimSpace(line.text[end+1:]); rest != "" {
+			if value, err = parseYAMLValue(rest); err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			p.pos++
+		} else {
+			p.pos++
+			if value, err = p.parseNested(indent, true); err != nil {
+				return nil, err
+			}
+		}
+		m[name] = value
+	}
+	return m, nil
+}
+
+// parseNested parses the block below a key or sequence item without a value, which is more
+// indented, or, below a key, may be a sequence at the same indent. Without one, the value is null.
+func (p *yamlParser) parseNested(indent int, key bool) (interface{}, error) {
+	if p.pos == len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent || (key && next.indent == indent && isYAMLItem(next.text)) {
+		return p.parseBlock(next.indent)
+	}
+	return nil, nil
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// yamlKeyEnd returns the index of the colon after the key of a mapping entry, or -1.
+func yamlKeyEnd(text string) int {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return -1
+	}
+	i := 0
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		i = yamlQuoteEnd(text)
+		if i < 0 {
+			return -1
+		}
+	}
+	for ; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+// yamlQuoteEnd returns the index after the quoted scalar at the start of text, or -1.
+func yamlQuoteEnd(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// stripYAMLComment returns the line without its comment, which starts with a # at the start of
+// the line or after a space, outside of quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if quote == '"' && c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseYAMLValue parses a flow sequence or mapping, or a scalar, which must be all of text.
+func parseYAMLValue(text string) (interface{}, error) {
+	value, rest, err := parseYAMLFlow(text, "")
+	if err == nil && strings.TrimSpace(rest) != "" {
+		err = fmt.Errorf("unexpected %q", rest)
+	}
+	return value, err
+}
+
+// parseYAMLFlow parses the flow sequence or mapping, or the scalar, at the start of text, in
+// which plain scalars end at one of the stop characters, and returns it with the rest of text.
+func parseYAMLFlow(text string, stop string) (interface{}, string, error) {
+	text = strings.TrimLeft(text, " ")
+	if text == "" {
+		return nil, "", nil
+	}
+	switch text[0] {
+	case '[', '{':
+		var seq []interface{}
+		m := map[string]interface{}{}
+		end := "]"
+		if text[0] == '{' {
+			end = "}"
+		}
+		text = strings.TrimLeft(text[1:], " ")
+		for !strings.HasPrefix(text, end) {
+			item, rest, err := parseYAMLFlow(text, ",:]}")
+			if err != nil {
+				return nil, "", err
+			}
+			rest = strings.TrimLeft(rest, " ")
+			if end == "}" {
+				name, ok := item.(string)
+				if !ok || !strings.HasPrefix(rest, ":") {
+					return nil, "", errors.New("expected a key and a colon in a flow mapping")
+				}
+				if item, rest, err = parseYAMLFlow(rest[1:], ",]}"); err != nil {
+					return nil, "", err
+				}
+				m[name] = item
+				rest = strings.TrimLeft(rest, " ")
+			} else {
+				seq = append(seq, item)
+			}
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimLeft(rest[1:], " ")
+			} else if !strings.HasPrefix(rest, end) {
+				return nil, "", fmt.Errorf("expected a comma or %s", end)
+			}
+			text = rest
+		}
+		if end == "}" {
+			return m, text[1:], nil
+		}
+		if seq == nil {
+			seq = []interface{}{}
+		}
+		return seq, text[1:], nil
+	case '"':
+		i := yamlQuoteEnd(text)
+		if i < 0 {
+			return nil, "", errors.New("unterminated double quoted scalar")
+		}
+		s, err := strconv.Unquote(text[:i])
+		return s, text[i:], err
+	case '\'':
+		i := yamlQuoteEnd(text)
+		if i < 0 {
+			return nil, "", errors.New("unterminated single quoted scalar")
+		}
+		return strings.Replace(text[1:i-1], "''", "'", -1), text[i:], nil
+	}
+	i := strings.IndexAny(text, stop)
+	if i < 0 {
+		i = len(text)
+	}
+	return strings.TrimRight(text[:i], " "), text[i:], nil
+}
+`
+
+var clientBenchmarkTemplate = `{{header}}
+
+package {{package}}
+
+import (
+{{if reqrep}}	"context"
+{{end}}	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// benchmarkBody is the response of the benchmark server, an example of the result of the call.
+const benchmarkBody = {{printf "%q" bench.Body}}
+
+// newBenchmarkServer starts a local server that answers all calls with 200 and benchmarkBody, so
+// that the benchmarks measure the client, and returns its URL.
+func newBenchmarkServer(b *testing.B) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, benchmarkBody)
+	}))
+	b.Cleanup(server.Close)
+	return server.URL
+}
+
+// Benchmark{{client}}Shared calls the server with a client created by NewClient, which makes all
+// its calls with one http.Client.
+func Benchmark{{client}}Shared(b *testing.B) {
+	client := NewClient(newBenchmarkServer(b), nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if {{bench.Results}} := client.{{bench.Call}}; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Benchmark{{client}}PerCall calls the server with a client that was not created by a constructor,
+// which builds an http.Client for each call. Compare its allocations per call with those of
+// Benchmark{{client}}Shared, i.e. with go test -bench . -benchmem.
+func Benchmark{{client}}PerCall(b *testing.B) {
+	client := {{client}}{URL: newBenchmarkServer(b)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if {{bench.Results}} := client.{{bench.Call}}; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`
+
+var fileAuthorizerTestTemplate = `{{header}}
+
+package {{package}}
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type policyPrincipal string
+
+func (p policyPrincipal) GetDomain() string         { return strings.SplitN(string(p), ".", 2)[0] }
+func (p policyPrincipal) GetName() string           { return strings.SplitN(string(p), ".", 2)[1] }
+func (p policyPrincipal) GetYRN() string            { return string(p) }
+func (p policyPrincipal) GetCredentials() string    { return "" }
+func (p policyPrincipal) GetHTTPHeaderName() string { return "" }
+
+// authorizeSpecs are the authorize statements of the schema, with the resource and domain
+// patterns that match them, and examples of the resources and domains they authorize.
+var authorizeSpecs = []struct {
+	name, action, resource, example, domain, domainExample string
+}{{"{"}}{{authorizeSpecs}}
+}
+
+func writePolicy(t *testing.T, path string, policy *AuthorizationPolicy) {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileAuthorizerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	for _, spec := range authorizeSpecs {
+		rule := AuthorizationRule{Principals: []string{"group:testers"}, Actions: []string{spec.action}, Resources: []string{spec.resource}}
+		if spec.domain != "" {
+			rule.Domains = []string{spec.domain}
+		}
+		writePolicy(t, path, &AuthorizationPolicy{Groups: map[string][]string{"testers": {"users.alice"}}, Rules: []AuthorizationRule{rule}})
+		authz, err := NewFileAuthorizer(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := authz.AuthorizeDomain(spec.action, spec.example, spec.domainExample, policyPrincipal("users.alice")); !ok || err != nil {
+			t.Errorf("%s: %s on %s is not allowed to a tester", spec.name, spec.action, spec.example)
+		}
+		if ok, _ := authz.AuthorizeDomain(spec.action, spec.example, spec.domainExample, policyPrincipal("users.bob")); ok {
+			t.Errorf("%s: %s on %s is allowed to anyone", spec.name, spec.action, spec.example)
+		}
+		if ok, _ := authz.AuthorizeDomain(spec.action+"-other", spec.example, spec.domainExample, policyPrincipal("users.alice")); ok {
+			t.Errorf("%s: any action on %s is allowed", spec.name, spec.example)
+		}
+	}
+}
+
+func TestFileAuthorizerReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	allow := AuthorizationRule{Principals: []string{"*"}, Actions: []string{"*"}, Resources: []string{"*"}}
+	writePolicy(t, path, &AuthorizationPolicy{Rules: []AuthorizationRule{allow}})
+	authz, err := NewFileAuthorizer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authz.ReloadInterval = 0
+	if ok, _ := authz.Authorize("read", "thing.a", policyPrincipal("users.mallory")); !ok {
+		t.Fatal("not allowed by a wildcard rule")
+	}
+	deny := AuthorizationRule{Principals: []string{"users.mallory"}, Actions: []string{"*"}, Resources: []string{"thing.*"}, Effect: "deny"}
+	writePolicy(t, path, &AuthorizationPolicy{Rules: []AuthorizationRule{allow, deny}})
+	if ok, _ := authz.Authorize("read", "thing.a", policyPrincipal("users.mallory")); ok {
+		t.Fatal("allowed after a deny rule was added")
+	}
+	if ok, _ := authz.Authorize("read", "thing.a", policyPrincipal("users.alice")); !ok {
+		t.Fatal("not allowed to others after a deny rule was added")
+	}
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := authz.Authorize("read", "thing.a", policyPrincipal("users.mallory")); ok {
+		t.Fatal("the previous policy was not kept when the file became invalid")
+	}
+}
+
+// yamlPolicy is the policy of the FileAuthorizer documentation in YAML, with the block and flow
+// styles, comments and quotes that parseYAML supports.
+var yamlPolicy = []string{
+	"# the administrators and the rules",
+	"groups:",
+	"  admins: [users.alice, 'users.bob']",
+	"rules:",
+	"- principals:",
+	"  - group:admins",
+	"  actions: [\"*\"]",
+	"  resources: [\"*\"]  # everything",
+	"-   principals: [\"*\"]",
+	"    actions:",
+	"      - read",
+	"    resources: [\"item.*\"]",
+	"- {principals: [users.mallory], actions: [\"*\"], resources: [\"*\"], effect: \"deny\"}",
+}
+
+func TestFileAuthorizerYAML(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(yamlPolicy, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	authz, err := NewFileAuthorizer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AuthorizationPolicy{
+		Groups: map[string][]string{"admins": {"users.alice", "users.bob"}},
+		Rules: []AuthorizationRule{
+			{Principals: []string{"group:admins"}, Actions: []string{"*"}, Resources: []string{"*"}},
+			{Principals: []string{"*"}, Actions: []string{"read"}, Resources: []string{"item.*"}},
+			{Principals: []string{"users.mallory"}, Actions: []string{"*"}, Resources: []string{"*"}, Effect: "deny"},
+		},
+	}
+	jsonPath := filepath.Join(dir, "policy.json")
+	writePolicy(t, jsonPath, &expected)
+	jsonAuthz, err := NewFileAuthorizer(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(authz.policy, jsonAuthz.policy) {
+		t.Fatalf("the YAML policy is %+v, expected %+v", authz.policy, jsonAuthz.policy)
+	}
+	if ok, _ := authz.Authorize("write", "thing.a", policyPrincipal("users.bob")); !ok {
+		t.Error("not allowed to an admin")
+	}
+	if ok, _ := authz.Authorize("read", "item.a", policyPrincipal("users.mallory")); ok {
+		t.Error("allowed to a denied principal")
+	}
+	for _, bad := range []string{"rules:\n- [a", "rules:\n  - a\n - b", "a: 1\na: 2", "\tgroups: {}", "rules: 'a"} {
+		if err := ioutil.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewFileAuthorizer(path); err == nil {
+			t.Errorf("the malformed policy %q is loaded", bad)
+		}
+	}
+}
 `
 
 func GenerateGoCLIMain(banner string, schema *rdl.Schema, outdir string, ns string, librdl string, prefixEnums bool, preciseTypes bool, untaggedUnions []string) error {
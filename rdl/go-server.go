@@ -8,24 +8,27 @@ import (
 	"fmt"
 	"log"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/ardielle/ardielle-go/gen/gomodel"
 	"github.com/ardielle/ardielle-go/rdl"
 )
 
 type serverGenerator struct {
-	registry    rdl.TypeRegistry
-	schema      *rdl.Schema
-	name        string
-	writer      *bufio.Writer
-	err         error
-	banner      string
-	prefixEnums bool
-	precise     bool
-	ns          string
-	librdl      string
+	registry        rdl.TypeRegistry
+	schema          *rdl.Schema
+	name            string
+	writer          *bufio.Writer
+	err             error
+	banner          string
+	prefixEnums     bool
+	precise         bool
+	ns              string
+	librdl          string
+	requestResponse bool
 }
 
 // GenerateGoServer generates the server code for the RDL-defined service
@@ -47,6 +50,21 @@ func GenerateGoServer(opts *generateOptions) error {
 	} else {
 		name = name + "_server.go"
 	}
+	reg := rdl.NewTypeRegistry(schema)
+	if err := goCheckFormBodies(reg, schema); err != nil {
+		return err
+	}
+	for _, r := range schema.Resources {
+		if _, err := goLimitDefault(reg, r, precise); err != nil {
+			return err
+		}
+		if !idempotentResource(r) {
+			continue
+		}
+		if _, err := goIdempotencyTTL(reg, r, precise); err != nil {
+			return err
+		}
+	}
 	filepath := outdir + "/" + name
 	out, file, _, err := outputWriter(filepath, "", ".go")
 	if err != nil {
@@ -61,9 +79,23 @@ func GenerateGoServer(opts *generateOptions) error {
 			}
 		}()
 	}
-	reg := rdl.NewTypeRegistry(schema)
-	gen := &serverGenerator{reg, schema, capitalize(string(schema.Name)), out, nil, banner, prefixEnums, precise, ns, librdl}
-	gen.processTemplate(serverTemplate)
+	if opts.requestResponse {
+		if err := GenerateGoRequestResponseTypes(opts); err != nil {
+			return err
+		}
+	}
+	if err := GenerateGoTraceContext(opts); err != nil {
+		return err
+	}
+	if opts.authenticators {
+		if err := GenerateGoServerAuthenticators(opts); err != nil {
+			return err
+		}
+	}
+	gen := &serverGenerator{reg, schema, capitalize(string(schema.Name)), out, nil, banner, prefixEnums, precise, ns, librdl, opts.requestResponse}
+	if err := gen.processTemplate(serverTemplate); err != nil {
+		return err
+	}
 	out.Flush()
 	return gen.err
 }
@@ -73,13 +105,27 @@ const serverTemplate = `{{header}}
 package {{package}}
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"{{if or (idempotent) (conditional)}}
+	"crypto/sha256"{{end}}
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net"
 	"net/url"
+	"os"{{if patches}}
+	"reflect"{{end}}
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	rdl "{{rdlruntime}}"
 	"{{httptreemux}}"
@@ -87,35 +133,275 @@ import (
 
 var _ = json.Marshal
 var _ = ioutil.Discard
+var _ = errors.Is
 
 //
 // Init initializes the {{name}} server with a service identity and an
 // implementation ({{cName}}Handler), and returns an http.Handler to serve it.
 //
 func Init(impl {{cName}}Handler, baseURL string, authz rdl.Authorizer, authns ...rdl.Authenticator) http.Handler {
+	return InitWithConfig(impl, baseURL, nil, authz, authns...)
+}
+
+//
+// {{cName}}ServerConfig holds the runtime settings of the {{name}} server. The zero value
+// uses only the defaults declared in the schema.
+//
+type {{cName}}ServerConfig struct {
+	// Limits overrides the limits declared in the schema (via the x_rate_limit, x_rate_burst,
+	// x_max_in_flight, x_max_body and x_timeout annotations), keyed by resource name.
+	Limits map[string]{{cName}}Limit
+
+	// LimitKey returns the key that limits are tracked by. Limits are checked before the request
+	// is authenticated, so the default is the client address.
+	LimitKey func(context *rdl.ResourceContext) string
+
+	// SpanHook, if set, is notified of the start and end of every request. The W3C trace
+	// context of requests is propagated to generated clients called with the request's
+	// context whether or not a hook is set.
+	SpanHook ServerSpanHook
+
+	// AuditSink, if set, receives an AuditEvent for every request that is authorized, and for
+	// every request with a method other than GET, HEAD and OPTIONS.
+	AuditSink AuditSink
+{{if idempotent}}
+	// IdempotencyStore keeps the responses of resources annotated with x_idempotency_key, to
+	// replay them on retries. The default is an in-memory store.
+	IdempotencyStore IdempotencyStore
+{{end}}}
+
+//
+// {{cName}}Limit restricts the requests to a resource for each key of the LimitKey of the config.
+// Rate is in requests per second, with bursts of up to Burst requests. MaxInFlight caps the
+// number of concurrent requests. MaxBody is the largest request body accepted, in bytes, and
+// Timeout is the time budget for the handler call, which stream resources do not have. A zero
+// value means no limit.
+//
+type {{cName}}Limit struct {
+	Rate        float64
+	Burst       int
+	MaxInFlight int
+	MaxBody     int64
+	Timeout     time.Duration
+}
+
+var defaultLimits = map[string]{{cName}}Limit{{openBrace}}{{range .Resources}}{{limitDefault .}}{{end}}
+}
+{{if idempotent}}
+// idempotencyTTLs are the times that responses of the resources annotated with x_idempotency_key are kept.
+var idempotencyTTLs = map[string]time.Duration{{openBrace}}{{range .Resources}}{{idempotencyTTL .}}{{end}}
+}
+{{end}}{{if selectable}}
+// selectableFields are the field paths that the fields query parameter of the resources annotated
+// with x_fields can select.
+var selectableFields = map[string]map[string]bool{{openBrace}}{{range .Resources}}{{fieldPaths .}}{{end}}
+}
+{{end}}
+//
+// InitWithConfig initializes the {{name}} server like Init, with the specified runtime configuration.
+//
+func InitWithConfig(impl {{cName}}Handler, baseURL string, config *{{cName}}ServerConfig, authz rdl.Authorizer, authns ...rdl.Authenticator) http.Handler {
+	router := NewRouter()
+	if err := Register(router, impl, baseURL, config, authz, authns...); err != nil {
+		log.Fatal(err)
+	}
+	return router
+}
+
+//
+// Register adds the routes of the {{name}} server at baseURL to a router, which may be shared
+// with other services registered under distinct base paths. An error is returned if a route
+// conflicts with one already registered.
+//
+func Register(router *httptreemux.TreeMux, impl {{cName}}Handler, baseURL string, config *{{cName}}ServerConfig, authz rdl.Authorizer, authns ...rdl.Authenticator) (err error) {
 	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if config == nil {
+		config = &{{cName}}ServerConfig{}
 	}
 	b := u.Path
-	router := httptreemux.New()
-	adaptor := {{name}}Adaptor{impl, authz, authns, b}
+	adaptor := {{name}}Adaptor{
+		impl:           impl,
+		authorizer:     authz,
+		authenticators: authns,
+		endpoint:       b,
+		limiter:        newResourceLimiter(config.Limits),
+		limitKey:       config.LimitKey,
+		spanHook:       config.SpanHook,
+		auditSink:      config.AuditSink,
+	}
+	if adaptor.limitKey == nil {
+		adaptor.limitKey = defaultLimitKey
+	}{{if idempotent}}
+	adaptor.idempotency = config.IdempotencyStore
+	if adaptor.idempotency == nil {
+		adaptor.idempotency = NewMemoryIdempotencyStore()
+	}{{end}}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot register {{name}} service at '%s': %v", baseURL, r)
+		}
+	}()
 {{range .Resources}}
-	router.{{uMethod .}}(b+"{{methodPath .}}", func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	router.{{uMethod .}}(b+"{{methodPath .}}", adaptor.{{if isStream .}}traced("{{cMethodName .}}", adaptor.audited{{else}}wrap{{end}}("{{cMethodName .}}", func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+		adaptor.{{handlerName .}}(w, r, ps)
+	})){{if isStream .}}){{end}}{{if isConditional .}}
+	router.HEAD(b+"{{methodPath .}}", adaptor.wrap("{{cMethodName .}}", func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 		adaptor.{{handlerName .}}(w, r, ps)
-	}){{end}}
+	})){{end}}{{end}}
+	log.Printf("Initialized {{name}} service at '%s'\n", baseURL)
+	return nil
+}
+
+//
+// NewRouter returns a router with JSON 404 and 405 responses, for one or more generated
+// services to Register on.
+//
+func NewRouter() *httptreemux.TreeMux {
+	router := httptreemux.New()
 	router.NotFoundHandler = func(w http.ResponseWriter, r *http.Request) {
 		rdl.JSONResponse(w, 404, rdl.ResourceError{Code: http.StatusNotFound, Message: "Not Found"})
 	}
-	log.Printf("Initialized {{name}} service at '%s'\n", baseURL)
+	router.MethodNotAllowedHandler = func(w http.ResponseWriter, r *http.Request, methods map[string]httptreemux.HandlerFunc) {
+		allow := make([]string, 0, len(methods))
+		for m := range methods {
+			allow = append(allow, m)
+		}
+		sort.Strings(allow)
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		rdl.JSONResponse(w, 405, rdl.ResourceError{Code: http.StatusMethodNotAllowed, Message: "Method Not Allowed"})
+	}
 	return router
 }
 
+//
+// NewInProcessTransport returns an http.RoundTripper that serves requests with handler, i.e. the
+// one returned by InitWithConfig, in the same process and without a network connection. A client
+// of the {{name}} service that uses it runs the same authentication, authorization, limits and
+// error responses as over the network, and its values are passed as JSON, so that the caller and
+// the implementation never share them. Response bodies are streamed, and closing one cancels the
+// context of its request. The requests come from the address "in-process:0", which is in host:port
+// form like that of a network peer, so that the default limit key and other code that splits it
+// get "in-process" as the host.
+//
+func NewInProcessTransport(handler http.Handler) http.RoundTripper {
+	return &inProcessTransport{handler: handler}
+}
+
+type inProcessTransport struct {
+	handler http.Handler
+}
+
+func (t *inProcessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	serverReq := req.Clone(ctx)
+	serverReq.RequestURI = req.URL.RequestURI()
+	serverReq.RemoteAddr = "in-process:0"
+	if serverReq.Host == "" {
+		serverReq.Host = req.URL.Host
+	}
+	if serverReq.Body == nil {
+		serverReq.Body = http.NoBody
+	}
+	body, pipe := io.Pipe()
+	w := &inProcessResponseWriter{header: make(http.Header), method: req.Method, body: pipe, ready: make(chan struct{})}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				w.fail(fmt.Errorf("in-process handler panic: %v", r))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			pipe.Close()
+		}()
+		t.handler.ServeHTTP(w, serverReq)
+	}()
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		cancel()
+		body.CloseWithError(ctx.Err())
+		return nil, ctx.Err()
+	}
+	if w.err != nil {
+		cancel()
+		return nil, w.err
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.sent,
+		Body:          &inProcessBody{PipeReader: body, cancel: cancel},
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+// inProcessResponseWriter passes the response of an in-process handler to the transport: the
+// status and headers once they are written, and then the body through a pipe.
+type inProcessResponseWriter struct {
+	header http.Header
+	sent   http.Header
+	method string
+	status int
+	err    error
+	body   *io.PipeWriter
+	once   sync.Once
+	ready  chan struct{}
+}
+
+func (w *inProcessResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *inProcessResponseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status, w.sent = status, w.header.Clone()
+		close(w.ready)
+	})
+}
+
+func (w *inProcessResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.method == "HEAD" || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return len(b), nil
+	}
+	return w.body.Write(b)
+}
+
+func (w *inProcessResponseWriter) Flush() {
+}
+
+func (w *inProcessResponseWriter) fail(err error) {
+	w.once.Do(func() {
+		w.err = err
+		close(w.ready)
+	})
+	w.body.CloseWithError(err)
+}
+
+// inProcessBody is the body of an in-process response. Closing it cancels the request.
+type inProcessBody struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (body *inProcessBody) Close() error {
+	body.cancel()
+	return body.PipeReader.CloseWithError(context.Canceled)
+}
+
 //
 // {{cName}}Handler is the interface that the service implementation must conform to
 //
 type {{cName}}Handler interface {{openBrace}}{{range .Resources}}
-	{{methodSig .}}{{end}}
+	{{methodSig .}}{{if isPatch .}}
+	{{patchTargetSig .}}{{end}}{{end}}
 	Authenticate(context *rdl.ResourceContext) bool
 }
 
@@ -127,6 +413,20 @@ type {{name}}Adaptor struct {
 	authorizer     rdl.Authorizer
 	authenticators []rdl.Authenticator
 	endpoint       string
+	limiter        *resourceLimiter
+	limitKey       func(context *rdl.ResourceContext) string
+	spanHook       ServerSpanHook
+	auditSink      AuditSink{{if idempotent}}
+	idempotency    IdempotencyStore{{end}}
+}
+
+// wrap applies the deadline, idempotency, auditing, and tracing of the resource to its route handler.
+func (adaptor {{name}}Adaptor) wrap(resource string, handler httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	handler = withDeadline(adaptor.limiter.limits[resource].Timeout, handler){{if idempotent}}
+	if _, ok := idempotencyTTLs[resource]; ok {
+		handler = adaptor.fingerprinted(resource, handler)
+	}{{end}}
+	return adaptor.traced(resource, adaptor.audited(resource, handler))
 }
 
 func (adaptor {{name}}Adaptor) authenticate(context *rdl.ResourceContext) bool {
@@ -146,38 +446,1354 @@ func (adaptor {{name}}Adaptor) authenticate(context *rdl.ResourceContext) bool {
 						}
 					}
 				}
-			} else {
-				creds, ok = context.Request.Header[header]
+			} else {
+				creds, ok = context.Request.Header[header]
+			}
+			if ok && len(creds) > 0 {
+				principal := authn.Authenticate(creds[0])
+				if principal != nil {
+					context.Principal = principal
+					audit(context, "", "", "", "")
+					return true
+				}
+			}
+		}
+	}
+	if adaptor.impl.Authenticate(context) {
+		audit(context, "", "", "", "")
+		return true
+	}
+	log.Println("*** Authentication failed against all authenticator(s)")
+	return false
+}
+
+//
+// DomainAuthorizer is an rdl.Authorizer that is also passed the domain of the authorize statement
+// of a resource. Other authorizers are passed "domain:resource" as the resource when the statement
+// has a domain.
+//
+type DomainAuthorizer interface {
+	rdl.Authorizer
+	AuthorizeDomain(action string, resource string, domain string, principal rdl.Principal) (bool, error)
+}
+
+func (adaptor {{name}}Adaptor) authorize(context *rdl.ResourceContext, action string, resource string, domain string) bool {
+	if adaptor.authorizer == nil {
+		return true
+	}
+	if !adaptor.authenticate(context) {
+		audit(context, action, resource, domain, "deny")
+		return false
+	}
+	var ok bool
+	var err error
+	if authz, isDomain := adaptor.authorizer.(DomainAuthorizer); isDomain {
+		ok, err = authz.AuthorizeDomain(action, resource, domain, context.Principal)
+	} else if domain != "" {
+		ok, err = adaptor.authorizer.Authorize(action, domain+":"+resource, context.Principal)
+	} else {
+		ok, err = adaptor.authorizer.Authorize(action, resource, context.Principal)
+	}
+	if err == nil {
+		if ok {
+			audit(context, action, resource, domain, "allow")
+		} else {
+			audit(context, action, resource, domain, "deny")
+		}
+		return ok
+	}
+	log.Println("*** Error when trying to authorize:", err)
+	audit(context, action, resource, domain, "deny")
+	return false
+}
+
+//
+// resourceLimiter keeps a token bucket and an in-flight count per resource and key.
+//
+type resourceLimiter struct {
+	mu       sync.Mutex
+	limits   map[string]{{cName}}Limit
+	buckets  map[string]*tokenBucket
+	inFlight map[string]int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newResourceLimiter(overrides map[string]{{cName}}Limit) *resourceLimiter {
+	limits := make(map[string]{{cName}}Limit)
+	for k, v := range defaultLimits {
+		limits[k] = v
+	}
+	for k, v := range overrides {
+		limits[k] = v
+	}
+	return &resourceLimiter{limits: limits, buckets: make(map[string]*tokenBucket), inFlight: make(map[string]int)}
+}
+
+//
+// acquire admits a request for the resource on behalf of key. If admitted, the returned function
+// must be called when the request completes. Otherwise it is nil, and the number of seconds
+// after which the client may retry is returned.
+//
+func (limiter *resourceLimiter) acquire(resource string, key string) (func(), int) {
+	limit, ok := limiter.limits[resource]
+	if !ok || (limit.Rate <= 0 && limit.MaxInFlight <= 0) {
+		return func() {}, 0
+	}
+	id := resource + " " + key
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limit.MaxInFlight > 0 && limiter.inFlight[id] >= limit.MaxInFlight {
+		return nil, 1
+	}
+	if limit.Rate > 0 {
+		burst := float64(limit.Burst)
+		if burst < 1 {
+			burst = 1
+		}
+		now := time.Now()
+		bucket, ok := limiter.buckets[id]
+		if !ok {
+			if len(limiter.buckets) >= 10000 {
+				limiter.prune(now)
+			}
+			bucket = &tokenBucket{tokens: burst, last: now}
+			limiter.buckets[id] = bucket
+		}
+		bucket.tokens += now.Sub(bucket.last).Seconds() * limit.Rate
+		if bucket.tokens > burst {
+			bucket.tokens = burst
+		}
+		bucket.last = now
+		if bucket.tokens < 1 {
+			return nil, int((1-bucket.tokens)/limit.Rate) + 1
+		}
+		bucket.tokens--
+	}
+	if limit.MaxInFlight <= 0 {
+		return func() {}, 0
+	}
+	limiter.inFlight[id]++
+	return func() {
+		limiter.mu.Lock()
+		if limiter.inFlight[id]--; limiter.inFlight[id] <= 0 {
+			delete(limiter.inFlight, id)
+		}
+		limiter.mu.Unlock()
+	}, 0
+}
+
+// prune forgets the buckets that have been idle long enough to refill completely.
+func (limiter *resourceLimiter) prune(now time.Time) {
+	for id, bucket := range limiter.buckets {
+		limit := limiter.limits[id[:strings.Index(id, " ")]]
+		if now.Sub(bucket.last).Seconds()*limit.Rate >= float64(limit.Burst)+1 {
+			delete(limiter.buckets, id)
+		}
+	}
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+//
+// limitedBody reads at most remaining bytes of a request body, and fails with errBodyTooLarge
+// beyond that.
+//
+type limitedBody struct {
+	body      io.Reader
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// body returns the request body, limited to the MaxBody of the resource if it has one.
+func (limiter *resourceLimiter) body(resource string, request *http.Request) io.Reader {
+	max := limiter.limits[resource].MaxBody
+	if max <= 0 {
+		return request.Body
+	}
+	if request.ContentLength > max {
+		return &limitedBody{request.Body, -1}
+	}
+	return &limitedBody{request.Body, max}
+}
+
+// maxDeadlineResponse is the largest response that withDeadline buffers. A handler that writes
+// more gets errResponseTooLarge from its writes, and the client gets 500.
+const maxDeadlineResponse = 10 << 20
+
+var errResponseTooLarge = errors.New("response too large to buffer under a deadline")
+
+//
+// deadlineWriter buffers a response, so that it can be discarded if the deadline expires
+// before the handler completes. As with http.TimeoutHandler, the handler writes into a header
+// of its own, which is copied to the response once the handler completes, and a handler that
+// runs past the deadline writes into a header that is discarded.
+//
+type deadlineWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+	tooLarge bool
+}
+
+func (dw *deadlineWriter) Header() http.Header {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if dw.timedOut {
+		return make(http.Header)
+	}
+	return dw.header
+}
+
+func (dw *deadlineWriter) WriteHeader(code int) {
+	if code < 100 || code > 999 {
+		panic(fmt.Sprintf("invalid WriteHeader code %v", code))
+	}
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if !dw.timedOut && dw.code == 0 {
+		dw.code = code
+	}
+}
+
+func (dw *deadlineWriter) Write(b []byte) (int, error) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if dw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if dw.code == 0 {
+		dw.code = http.StatusOK
+	}
+	if dw.tooLarge || dw.buf.Len()+len(b) > maxDeadlineResponse {
+		dw.tooLarge = true
+		return 0, errResponseTooLarge
+	}
+	return dw.buf.Write(b)
+}
+
+// deadlineBody is the request body of a handler run by withDeadline. It is closed when the
+// deadline expires, so that a handler that is still running cannot read the body after the
+// request has been answered.
+type deadlineBody struct {
+	mu     sync.Mutex
+	body   io.ReadCloser
+	closed bool
+}
+
+func (db *deadlineBody) Read(p []byte) (int, error) {
+	db.mu.Lock()
+	closed := db.closed
+	db.mu.Unlock()
+	if closed {
+		return 0, http.ErrHandlerTimeout
+	}
+	return db.body.Read(p)
+}
+
+func (db *deadlineBody) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	return db.body.Close()
+}
+
+//
+// withDeadline runs the handler with a request context that expires after the timeout. If the
+// handler has not completed by then, its response is discarded, its request body is closed,
+// and 503 is returned instead. The handler is not interrupted: it keeps running until it
+// returns, and should stop early when the context of its request is done. The response is
+// buffered in memory until the handler completes, up to maxDeadlineResponse, and nothing is
+// sent before then: resources with large or slow responses should not have a timeout. Stream
+// resources never have one.
+//
+func withDeadline(timeout time.Duration, handler httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	if timeout <= 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		dw := &deadlineWriter{header: make(http.Header)}
+		body := &deadlineBody{body: r.Body}
+		r = r.WithContext(ctx)
+		r.Body = body
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			handler(dw, r, ps)
+			close(done)
+		}()
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			dw.mu.Lock()
+			defer dw.mu.Unlock()
+			if dw.tooLarge {
+				rdl.JSONResponse(w, 500, rdl.ResourceError{Code: http.StatusInternalServerError, Message: errResponseTooLarge.Error()})
+				return
+			}
+			dst := w.Header()
+			for k, v := range dw.header {
+				dst[k] = v
+			}
+			if dw.code == 0 {
+				dw.code = http.StatusOK
+			}
+			w.WriteHeader(dw.code)
+			w.Write(dw.buf.Bytes())
+		case <-ctx.Done():
+			dw.mu.Lock()
+			defer dw.mu.Unlock()
+			dw.timedOut = true
+			body.Close()
+			rdl.JSONResponse(w, 503, rdl.ResourceError{Code: http.StatusServiceUnavailable, Message: "Deadline Exceeded"})
+		}
+	}
+}
+
+//
+// ServerSpan describes the handling of one request. The IDs are the hex encoded W3C trace
+// context IDs: ParentID is the span ID of the caller, and is empty for a new trace. End,
+// Status, and Err are set when the request completes.
+//
+type ServerSpan struct {
+	Resource   string
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	TraceState string
+	Start      time.Time
+	End        time.Time
+	Status     int
+	Err        error
+}
+
+//
+// ServerSpanHook is the interface to plug a tracer into the server. SpanStart may return a
+// derived context, which is then used for the request. The trace context that the clients called
+// with it propagate is the one the hook sets with ContextWithTraceParent, if any, or else the
+// span's.
+//
+type ServerSpanHook interface {
+	SpanStart(ctx context.Context, span *ServerSpan) context.Context
+	SpanEnd(ctx context.Context, span *ServerSpan)
+}
+
+//
+// ServerSpanRecorder is a ServerSpanHook that keeps the completed spans in memory, i.e. for tests.
+//
+type ServerSpanRecorder struct {
+	mu    sync.Mutex
+	spans []ServerSpan
+}
+
+func (recorder *ServerSpanRecorder) SpanStart(ctx context.Context, span *ServerSpan) context.Context {
+	return ctx
+}
+
+func (recorder *ServerSpanRecorder) SpanEnd(ctx context.Context, span *ServerSpan) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.spans = append(recorder.spans, *span)
+}
+
+// Spans returns the spans completed so far, in order of completion.
+func (recorder *ServerSpanRecorder) Spans() []ServerSpan {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return append([]ServerSpan(nil), recorder.spans...)
+}
+
+type serverSpanKey struct{}
+
+// serverSpanState guards the span against the handler still running after its deadline.
+type serverSpanState struct {
+	mu    sync.Mutex
+	span  *ServerSpan
+	ended bool
+}
+
+//
+// statusWriter records the status code written, for the span.
+//
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets event streams reach the underlying writer, to flush them.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+//
+// traced extracts the W3C trace context of the request into its context and, if there is a span
+// hook, reports a span for the request that is the parent of any client calls made with it.
+//
+func (adaptor {{name}}Adaptor) traced(resource string, handler httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+		traceParent := r.Header.Get("traceparent")
+		traceState := r.Header.Get("tracestate")
+		traceID, parentID, flags := parseTraceParent(traceParent)
+		if adaptor.spanHook == nil {
+			if traceID != "" {
+				r = r.WithContext(ContextWithTraceParent(r.Context(), traceParent, traceState))
+			}
+			handler(w, r, ps)
+			return
+		}
+		if traceID == "" {
+			traceID, flags, traceState = randomHex(16), "01", ""
+		}
+		span := &ServerSpan{Resource: resource, TraceID: traceID, SpanID: randomHex(8), ParentID: parentID, TraceState: traceState, Start: time.Now()}
+		state := &serverSpanState{span: span}
+		ctx := context.WithValue(r.Context(), serverSpanKey{}, state)
+		outerParent, outerState := TraceParentFromContext(ctx)
+		ctx = adaptor.spanHook.SpanStart(ctx, span)
+		if hookParent, hookState := TraceParentFromContext(ctx); hookParent == outerParent && hookState == outerState {
+			ctx = ContextWithTraceParent(ctx, "00-"+span.TraceID+"-"+span.SpanID+"-"+flags, span.TraceState)
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			state.mu.Lock()
+			state.ended = true
+			span.End = time.Now()
+			span.Status = sw.status
+			if span.Status == 0 {
+				span.Status = http.StatusInternalServerError
+			}
+			state.mu.Unlock()
+			adaptor.spanHook.SpanEnd(ctx, span)
+		}()
+		handler(sw, r.WithContext(ctx), ps)
+	}
+}
+
+// spanError records the error returned by the implementation in the span of the request, if any.
+func spanError(request *http.Request, err error) {
+	if state, ok := request.Context().Value(serverSpanKey{}).(*serverSpanState); ok {
+		state.mu.Lock()
+		if !state.ended {
+			state.span.Err = err
+		}
+		state.mu.Unlock()
+	}
+}
+
+// parseTraceParent returns the trace ID, parent span ID, and flags of a version 00 traceparent.
+func parseTraceParent(traceParent string) (string, string, string) {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", ""
+	}
+	if (parts[0] == "00" && len(parts) != 4) || !isHex(parts[0]+parts[3]) {
+		return "", "", ""
+	}
+	if !isHex(parts[1]) || !isHex(parts[2]) || strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", ""
+	}
+	return parts[1], parts[2], parts[3]
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//
+// AuditEvent records an authorization decision or a mutating call. Action and Resource are the
+// authorized action and the resource string with its parameters expanded, Domain is the domain
+// of the authorize statement if it has one, and Decision is "allow" or "deny". They are empty
+// for calls to resources without an authorize statement, and Principal is empty if the caller
+// was not authenticated. Operation is the name of the RDL resource, i.e. "PutItem", and Status
+// is the status code of the response.
+//
+type AuditEvent struct {
+	Time      time.Time ` + "`" + `json:"time"` + "`" + `
+	Principal string    ` + "`" + `json:"principal,omitempty"` + "`" + `
+	Action    string    ` + "`" + `json:"action,omitempty"` + "`" + `
+	Resource  string    ` + "`" + `json:"resource,omitempty"` + "`" + `
+	Domain    string    ` + "`" + `json:"domain,omitempty"` + "`" + `
+	Decision  string    ` + "`" + `json:"decision,omitempty"` + "`" + `
+	Operation string    ` + "`" + `json:"operation"` + "`" + `
+	Method    string    ` + "`" + `json:"method"` + "`" + `
+	Status    int       ` + "`" + `json:"status"` + "`" + `
+}
+
+//
+// AuditSink is the interface to plug an audit trail into the server. Audit is called once the
+// response has been written, and may be called concurrently.
+//
+type AuditSink interface {
+	Audit(event *AuditEvent)
+}
+
+//
+// AuditLog is an AuditSink that writes the events as JSON lines.
+//
+type AuditLog struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewAuditLog returns an AuditLog that writes to writer.
+func NewAuditLog(writer io.Writer) *AuditLog {
+	return &AuditLog{writer: writer}
+}
+
+// OpenAuditLog returns an AuditLog that appends to the file at path, creating it if needed.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditLog(f), nil
+}
+
+func (al *AuditLog) Audit(event *AuditEvent) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Println("*** Cannot encode audit event:", err)
+		return
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if _, err := al.writer.Write(append(b, '\n')); err != nil {
+		log.Println("*** Cannot write audit event:", err)
+	}
+}
+
+//
+// AuditRecorder is an AuditSink that keeps the events in memory, i.e. for tests.
+//
+type AuditRecorder struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (recorder *AuditRecorder) Audit(event *AuditEvent) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.events = append(recorder.events, *event)
+}
+
+// Events returns the events audited so far, in order.
+func (recorder *AuditRecorder) Events() []AuditEvent {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return append([]AuditEvent(nil), recorder.events...)
+}
+
+type auditKey struct{}
+
+// auditState guards the event against the handler still running after its deadline.
+type auditState struct {
+	mu    sync.Mutex
+	event *AuditEvent
+	ended bool
+}
+
+//
+// audited reports an AuditEvent to the sink when the request completes, if it was authorized or
+// is mutating.
+//
+func (adaptor {{name}}Adaptor) audited(resource string, handler httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	if adaptor.auditSink == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+		state := &auditState{event: &AuditEvent{Time: time.Now().UTC(), Operation: resource, Method: r.Method}}
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			state.mu.Lock()
+			state.ended = true
+			event := *state.event
+			state.mu.Unlock()
+			switch r.Method {
+			case "GET", "HEAD", "OPTIONS":
+				if event.Decision == "" {
+					return
+				}
+			}
+			event.Status = sw.status
+			if event.Status == 0 {
+				event.Status = http.StatusInternalServerError
+			}
+			adaptor.auditSink.Audit(&event)
+		}()
+		handler(sw, r.WithContext(context.WithValue(r.Context(), auditKey{}, state)), ps)
+	}
+}
+
+// audit records the principal of the request, and the authorization decision if there is one,
+// in its audit event.
+func audit(context *rdl.ResourceContext, action string, resource string, domain string, decision string) {
+	state, ok := context.Request.Context().Value(auditKey{}).(*auditState)
+	if !ok {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.ended {
+		return
+	}
+	if p := context.Principal; p != nil {
+		state.event.Principal = p.GetName()
+		if p.GetDomain() != "" {
+			state.event.Principal = p.GetDomain() + "." + p.GetName()
+		}
+	}
+	if decision != "" {
+		state.event.Action = action
+		state.event.Resource = resource
+		state.event.Domain = domain
+		state.event.Decision = decision
+	}
+}
+
+{{if idempotent}}//
+// IdempotentResponse is a response recorded for an idempotency key.
+//
+type IdempotentResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// ErrIdempotencyConflict is returned by an IdempotencyStore when a key is in use by a request
+// that is still in progress, or was used for a different request.
+var ErrIdempotencyConflict = errors.New("idempotency key conflict")
+
+//
+// IdempotencyStore is the interface for storing the responses to requests that carry an
+// Idempotency-Key header. Keys are scoped to a resource by the caller. The fingerprint
+// identifies the request the key was first used with.
+//
+type IdempotencyStore interface {
+	// Reserve claims the key for a new request, returning nil. If the key was already used for
+	// the same request and its response recorded, that response is returned instead. Otherwise
+	// ErrIdempotencyConflict is returned.
+	Reserve(key string, fingerprint string, ttl time.Duration) (*IdempotentResponse, error)
+
+	// Save records the response for a key that was reserved.
+	Save(key string, response *IdempotentResponse)
+
+	// Release forgets a key that was reserved, so that the request can be retried.
+	Release(key string)
+}
+
+//
+// MemoryIdempotencyStore is an IdempotencyStore that keeps the responses in memory, until their
+// TTL expires.
+//
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastPrune time.Time
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	response    *IdempotentResponse
+	expires     time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]*idempotencyEntry)}
+}
+
+func (store *MemoryIdempotencyStore) Reserve(key string, fingerprint string, ttl time.Duration) (*IdempotentResponse, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	if now.Sub(store.lastPrune) > time.Minute {
+		for k, e := range store.entries {
+			if now.After(e.expires) {
+				delete(store.entries, k)
+			}
+		}
+		store.lastPrune = now
+	}
+	if e, ok := store.entries[key]; ok && now.Before(e.expires) {
+		if e.response == nil || e.fingerprint != fingerprint {
+			return nil, ErrIdempotencyConflict
+		}
+		return e.response, nil
+	}
+	store.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expires: now.Add(ttl)}
+	return nil, nil
+}
+
+func (store *MemoryIdempotencyStore) Save(key string, response *IdempotentResponse) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if e, ok := store.entries[key]; ok {
+		e.response = response
+	}
+}
+
+func (store *MemoryIdempotencyStore) Release(key string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.entries, key)
+}
+
+//
+// recordingWriter passes a response through, keeping a copy of it.
+//
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+type idempotencyFingerprintKey struct{}
+
+//
+// fingerprinted buffers the body of requests with an Idempotency-Key header, and keeps a hash of
+// their method, URI and body in the request context, for idempotent to compare with the request
+// the key was first used with.
+//
+func (adaptor {{name}}Adaptor) fingerprinted(resource string, handler httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+		if r.Header.Get("Idempotency-Key") == "" {
+			handler(w, r, ps)
+			return
+		}
+		body, err := ioutil.ReadAll(adaptor.limiter.body(resource, r))
+		if err == errBodyTooLarge {
+			rdl.JSONResponse(w, http.StatusRequestEntityTooLarge, rdl.ResourceError{Code: http.StatusRequestEntityTooLarge, Message: "Request Entity Too Large"})
+			return
+		} else if err != nil {
+			rdl.JSONResponse(w, http.StatusBadRequest, rdl.ResourceError{Code: http.StatusBadRequest, Message: "Bad request: " + err.Error()})
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+		r = r.WithContext(context.WithValue(r.Context(), idempotencyFingerprintKey{}, hex.EncodeToString(sum[:])))
+		handler(w, r, ps)
+	}
+}
+
+//
+// idempotent is called by the handlers of resources annotated with x_idempotency_key once the
+// request is authorized. It replays the first response to requests with the same Idempotency-Key
+// header from the same principal, and returns a nil done function when it has answered the
+// request. Concurrent duplicates, and reuse of a key for a different request, are answered with
+// 409. Otherwise the returned writer records the response, and done keeps it, unless it is one
+// that the client is expected to retry, such as 429 or 5xx.
+//
+func (adaptor {{name}}Adaptor) idempotent(resource string, context *rdl.ResourceContext, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	key := r.Header.Get("Idempotency-Key")
+	fingerprint, _ := r.Context().Value(idempotencyFingerprintKey{}).(string)
+	if key == "" || fingerprint == "" {
+		return w, func() {}
+	}
+	key = resource + " " + idempotencyScope(context) + " " + key
+	recorded, err := adaptor.idempotency.Reserve(key, fingerprint, idempotencyTTLs[resource])
+	if err == ErrIdempotencyConflict {
+		rdl.JSONResponse(w, http.StatusConflict, rdl.ResourceError{Code: http.StatusConflict, Message: "Idempotency-Key is in use by another request"})
+		return w, nil
+	} else if err != nil {
+		rdl.JSONResponse(w, http.StatusServiceUnavailable, rdl.ResourceError{Code: http.StatusServiceUnavailable, Message: err.Error()})
+		return w, nil
+	}
+	if recorded != nil {
+		for k, v := range recorded.Header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(recorded.Status)
+		w.Write(recorded.Body)
+		return w, nil
+	}
+	rw := &recordingWriter{ResponseWriter: w}
+	return rw, func() {
+		switch {
+		case rw.status == 0, rw.status >= 500, rw.status == 401, rw.status == 403, rw.status == 408, rw.status == 409, rw.status == 429:
+			adaptor.idempotency.Release(key)
+			return
+		}
+		header := make(http.Header)
+		for k, v := range w.Header() {
+			header[k] = v
+		}
+		adaptor.idempotency.Save(key, &IdempotentResponse{Status: rw.status, Header: header, Body: rw.body.Bytes()})
+	}
+}
+
+// idempotencyScope returns the part of an idempotency key that identifies the caller, so that a
+// key is only replayed to the caller that used it: the name of the authenticated principal, or a
+// hash of the Authorization header and cookies of requests that are not authenticated.
+func idempotencyScope(context *rdl.ResourceContext) string {
+	if p := context.Principal; p != nil {
+		return p.GetDomain() + "." + p.GetName()
+	}
+	h := sha256.New()
+	io.WriteString(h, context.Request.Header.Get("Authorization"))
+	for _, c := range context.Request.Header["Cookie"] {
+		io.WriteString(h, "\n"+c)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+{{end}}{{if streams}}//
+// eventStream writes Server-Sent Events to a response, numbering them from 1. The response is
+// flushed after each event, and sending fails once the client has disconnected. The WriteTimeout
+// of the http.Server, if any, also applies to streams, and ends them.
+//
+type eventStream struct {
+	writer  http.ResponseWriter
+	request *http.Request
+	flusher http.Flusher
+	id      int64
+}
+
+func newEventStream(writer http.ResponseWriter, request *http.Request) *eventStream {
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	stream := &eventStream{writer: writer, request: request, flusher: findFlusher(writer)}
+	stream.flush()
+	return stream
+}
+
+// findFlusher returns the http.Flusher of the writer, or of the writer it wraps, or nil.
+func findFlusher(writer http.ResponseWriter) http.Flusher {
+	for {
+		if flusher, ok := writer.(http.Flusher); ok {
+			return flusher
+		}
+		wrapper, ok := writer.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		writer = wrapper.Unwrap()
+	}
+}
+
+func (stream *eventStream) flush() error {
+	if stream.flusher == nil {
+		return http.ErrNotSupported
+	}
+	stream.flusher.Flush()
+	return nil
+}
+
+func (stream *eventStream) send(event string, data interface{}) error {
+	if err := stream.request.Context().Err(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	stream.id++
+	frame := "id: " + strconv.FormatInt(stream.id, 10) + "\n"
+	if event != "" {
+		frame += "event: " + event + "\n"
+	}
+	frame += "data: " + string(b) + "\n\n"
+	if _, err := io.WriteString(stream.writer, frame); err != nil {
+		return err
+	}
+	return stream.flush()
+}
+
+{{end}}{{if patches}}//
+// requestPatch is a JSON merge patch (RFC 7396) or JSON Patch (RFC 6902) request body, to apply
+// to the current value of a resource.
+//
+type requestPatch struct {
+	contentType string
+	body        []byte
+}
+
+// readPatch reads the body of a PATCH request. A patch of one of the allowed content types is
+// returned, to be applied later. Any other body is decoded as JSON into v.
+func readPatch(request *http.Request, body io.Reader, v interface{}, allowed string) (*requestPatch, error) {
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(request.Header.Get("Content-Type"), ";")[0]))
+	switch contentType {
+	case "application/merge-patch+json", "application/json-patch+json":
+		if !strings.Contains(allowed, contentType) {
+			return nil, &rdl.ResourceError{Code: http.StatusUnsupportedMediaType, Message: "Unsupported Media Type: " + contentType}
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		return &requestPatch{contentType: contentType, body: data}, nil
+	}
+	return nil, json.NewDecoder(body).Decode(v)
+}
+
+// apply applies the patch to the JSON of target, and decodes the result into v, which validates
+// it against its type.
+func (patch *requestPatch) apply(target interface{}, v interface{}) error {
+	current, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return err
+	}
+	if patch.contentType == "application/merge-patch+json" {
+		var p interface{}
+		if err := json.Unmarshal(patch.body, &p); err != nil {
+			return &rdl.ResourceError{Code: http.StatusBadRequest, Message: "Bad request: " + err.Error()}
+		}
+		doc = mergePatch(doc, p)
+	} else {
+		var ops []jsonPatchOp
+		if err := json.Unmarshal(patch.body, &ops); err != nil {
+			return &rdl.ResourceError{Code: http.StatusBadRequest, Message: "Bad request: " + err.Error()}
+		}
+		if doc, err = applyJSONPatch(doc, ops); err != nil {
+			return err
+		}
+	}
+	result, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(result, v); err != nil {
+		return &rdl.ResourceError{Code: http.StatusUnprocessableEntity, Message: "Invalid result of patch: " + err.Error()}
+	}
+	return nil
+}
+
+func mergePatch(doc interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+		} else {
+			d[k] = mergePatch(d[k], v)
+		}
+	}
+	return d
+}
+
+type jsonPatchOp struct {
+	Op    string          ` + "`" + `json:"op"` + "`" + `
+	Path  string          ` + "`" + `json:"path"` + "`" + `
+	From  string          ` + "`" + `json:"from"` + "`" + `
+	Value json.RawMessage ` + "`" + `json:"value"` + "`" + `
+}
+
+func applyJSONPatch(doc interface{}, ops []jsonPatchOp) (interface{}, error) {
+	for _, op := range ops {
+		path, err := parseJSONPointer(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return nil, &rdl.ResourceError{Code: http.StatusBadRequest, Message: "Bad request: missing value for " + op.Op}
+			}
+			json.Unmarshal(op.Value, &value)
+		case "move", "copy":
+			from, err := parseJSONPointer(op.From)
+			if err != nil {
+				return nil, err
+			}
+			if op.Op == "move" {
+				doc, value, err = jsonPointerRemove(doc, from)
+			} else if value, err = jsonPointerGet(doc, from); err == nil {
+				b, _ := json.Marshal(value)
+				json.Unmarshal(b, &value)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case "remove":
+		default:
+			return nil, &rdl.ResourceError{Code: http.StatusBadRequest, Message: "Bad request: unknown patch op '" + op.Op + "'"}
+		}
+		switch op.Op {
+		case "add", "move", "copy":
+			doc, err = jsonPointerAdd(doc, path, value)
+		case "remove":
+			doc, _, err = jsonPointerRemove(doc, path)
+		case "replace":
+			if doc, _, err = jsonPointerRemove(doc, path); err == nil {
+				doc, err = jsonPointerAdd(doc, path, value)
+			}
+		case "test":
+			var current interface{}
+			if current, err = jsonPointerGet(doc, path); err == nil && !reflect.DeepEqual(current, value) {
+				err = &rdl.ResourceError{Code: http.StatusConflict, Message: "Patch test failed at " + op.Path}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, &rdl.ResourceError{Code: http.StatusBadRequest, Message: "Bad request: bad JSON pointer '" + pointer + "'"}
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func jsonPointerIndex(token string, n int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= n || (len(token) > 1 && token[0] == '0') {
+		return 0, &rdl.ResourceError{Code: http.StatusConflict, Message: "Patch path not found: " + token}
+	}
+	return i, nil
+}
+
+func jsonPointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			child, ok := node[token]
+			if !ok {
+				return nil, &rdl.ResourceError{Code: http.StatusConflict, Message: "Patch path not found: " + token}
+			}
+			doc = child
+		case []interface{}:
+			i, err := jsonPointerIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, &rdl.ResourceError{Code: http.StatusConflict, Message: "Patch path not found: " + token}
+		}
+	}
+	return doc, nil
+}
+
+func jsonPointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token := path[0]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			node[token] = value
+			return node, nil
+		}
+		child, ok := node[token]
+		if !ok {
+			break
+		}
+		child, err := jsonPointerAdd(child, path[1:], value)
+		node[token] = child
+		return node, err
+	case []interface{}:
+		if len(path) == 1 {
+			i := len(node)
+			if token != "-" {
+				var err error
+				if i, err = jsonPointerIndex(token, len(node)+1); err != nil {
+					return nil, err
+				}
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		i, err := jsonPointerIndex(token, len(node))
+		if err != nil {
+			return nil, err
+		}
+		node[i], err = jsonPointerAdd(node[i], path[1:], value)
+		return node, err
+	}
+	return nil, &rdl.ResourceError{Code: http.StatusConflict, Message: "Patch path not found: " + token}
+}
+
+func jsonPointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	token := path[0]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+		if !ok {
+			break
+		}
+		if len(path) == 1 {
+			delete(node, token)
+			return node, child, nil
+		}
+		child, removed, err := jsonPointerRemove(child, path[1:])
+		node[token] = child
+		return node, removed, err
+	case []interface{}:
+		i, err := jsonPointerIndex(token, len(node))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(path) == 1 {
+			removed := node[i]
+			return append(node[:i], node[i+1:]...), removed, nil
+		}
+		child, removed, err := jsonPointerRemove(node[i], path[1:])
+		node[i] = child
+		return node, removed, err
+	}
+	return nil, nil, &rdl.ResourceError{Code: http.StatusConflict, Message: "Patch path not found: " + token}
+}
+
+{{end}}{{if selectable}}//
+// fieldSelection is the tree of field names selected by the fields query parameter. A nil subtree
+// selects the whole value of the field.
+//
+type fieldSelection map[string]fieldSelection
+
+// selectFields parses the comma separated field paths of the fields query parameter. A nil
+// selection is returned when the parameter is absent, to leave responses as they are.
+func selectFields(request *http.Request, resource string) (fieldSelection, error) {
+	var selection fieldSelection
+	for _, param := range request.URL.Query()["fields"] {
+		for _, path := range strings.Split(param, ",") {
+			path = strings.TrimSpace(path)
+			if path == "" {
+				continue
+			}
+			if !selectableFields[resource][path] {
+				return nil, &rdl.ResourceError{Code: http.StatusBadRequest, Message: "Unknown field: " + path}
+			}
+			if selection == nil {
+				selection = fieldSelection{}
+			}
+			names := strings.Split(path, ".")
+			node := selection
+			for i, name := range names {
+				child, ok := node[name]
+				if ok && child == nil {
+					break
+				}
+				if i == len(names)-1 {
+					node[name] = nil
+					break
+				}
+				if !ok {
+					child = fieldSelection{}
+					node[name] = child
+				}
+				node = child
+			}
+		}
+	}
+	return selection, nil
+}
+
+// prune returns the JSON of data with only the selected fields, or data itself if there is
+// no selection.
+func (selection fieldSelection) prune(data interface{}) interface{} {
+	if selection == nil {
+		return data
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return data
+	}
+	return selection.apply(doc)
+}
+
+func (selection fieldSelection) apply(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			if child, ok := selection[name]; !ok {
+				delete(v, name)
+			} else if child != nil {
+				v[name] = child.apply(value)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = selection.apply(item)
+		}
+	}
+	return doc
+}
+
+{{end}}{{if formBodies}}//
+// decodeFormBody decodes a form or multipart form request body into the struct v, via the JSON
+// names of its fields. The kinds of the fields ("string", "number", "bool" or "bytes") say how the
+// form values are converted. Bytes fields are read from the uploaded files.
+//
+func decodeFormBody(request *http.Request, body io.Reader, v interface{}, kinds map[string]string) error {
+	request.Body = ioutil.NopCloser(body)
+	var values url.Values
+	multipartForm := strings.HasPrefix(request.Header.Get("Content-Type"), "multipart/")
+	if multipartForm {
+		if err := request.ParseMultipartForm(32 << 20); err != nil {
+			return err
+		}
+		defer request.MultipartForm.RemoveAll()
+		values = request.MultipartForm.Value
+	} else {
+		if err := request.ParseForm(); err != nil {
+			return err
+		}
+		values = request.PostForm
+	}
+	obj := make(map[string]interface{})
+	for name, kind := range kinds {
+		//array fields take all the values (or files) of their name, other fields the first
+		elemKind := strings.TrimPrefix(kind, "[]")
+		var elems []interface{}
+		if elemKind == "bytes" {
+			if !multipartForm {
+				continue
+			}
+			for _, fh := range request.MultipartForm.File[name] {
+				f, err := fh.Open()
+				if err != nil {
+					return err
+				}
+				data, err := ioutil.ReadAll(f)
+				f.Close()
+				if err != nil {
+					return err
+				}
+				elems = append(elems, data)
 			}
-			if ok && len(creds) > 0 {
-				principal := authn.Authenticate(creds[0])
-				if principal != nil {
-					context.Principal = principal
-					return true
+		} else {
+			for _, value := range values[name] {
+				switch elemKind {
+				case "number":
+					if _, err := strconv.ParseFloat(value, 64); err != nil {
+						return fmt.Errorf("bad number for %s: %q", name, value)
+					}
+					elems = append(elems, json.Number(value))
+				case "bool":
+					b, err := strconv.ParseBool(value)
+					if err != nil {
+						return fmt.Errorf("bad bool for %s: %q", name, value)
+					}
+					elems = append(elems, b)
+				default:
+					elems = append(elems, value)
 				}
 			}
 		}
+		if len(elems) == 0 {
+			continue
+		}
+		if kind != elemKind {
+			obj[name] = elems
+		} else {
+			obj[name] = elems[0]
+		}
 	}
-	if adaptor.impl.Authenticate(context) {
-		return true
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
 	}
-	log.Println("*** Authentication failed against all authenticator(s)")
-	return false
+	return json.Unmarshal(data, v)
 }
 
-func (adaptor {{name}}Adaptor) authorize(context *rdl.ResourceContext, action string, resource string) bool {
-	if adaptor.authorizer == nil {
-		return true
-	}
-	if !adaptor.authenticate(context) {
-		return false
-	}
-	ok, err := adaptor.authorizer.Authorize(action, resource, context.Principal)
-	if err == nil {
-		return ok
+{{end}}func defaultLimitKey(context *rdl.ResourceContext) string {
+	host, _, err := net.SplitHostPort(context.Request.RemoteAddr)
+	if err != nil {
+		return context.Request.RemoteAddr
 	}
-	log.Println("*** Error when trying to authorize:", err)
-	return false
+	return host
 }
 
 func intFromString(s string) int64 {
@@ -191,7 +1807,67 @@ func floatFromString(s string) float64 {
 	_, _ = fmt.Sscanf(s, "%g", &n)
 	return n
 }
-{{range .Resources}}
+{{if conditional}}
+//
+// conditionalResponse writes data as JSON, tagged with a strong ETag computed from the serialized
+// body unless the implementation already provided one. If the request's If-None-Match or
+// If-Modified-Since preconditions show that the client's copy is current, 304 is written instead.
+// The body is omitted for HEAD requests, but all headers are the same as for GET.
+//
+func conditionalResponse(writer http.ResponseWriter, request *http.Request, code int, data interface{}) {
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		rdl.JSONResponse(writer, 500, rdl.ResourceError{Code: http.StatusInternalServerError, Message: "Server Error"})
+		return
+	}
+	b = append(b, '\n')
+	header := writer.Header()
+	etag := header.Get("ETag")
+	if etag == "" {
+		sum := sha256.Sum256(b)
+		etag = "\"" + hex.EncodeToString(sum[:]) + "\""
+		header.Set("ETag", etag)
+	}
+	if code == http.StatusOK && notModified(request, etag, header.Get("Last-Modified")) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(b)))
+	writer.WriteHeader(code)
+	if request.Method != "HEAD" {
+		writer.Write(b)
+	}
+}
+
+//
+// notModified evaluates the request preconditions as described in RFC 7232. If-Modified-Since
+// is only considered when If-None-Match is absent and the resource reports a Last-Modified time.
+//
+func notModified(request *http.Request, etag string, lastModified string) bool {
+	if inm := request.Header["If-None-Match"]; len(inm) > 0 {
+		for _, tag := range strings.Split(strings.Join(inm, ","), ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := request.Header.Get("If-Modified-Since"); ims != "" && lastModified != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(lastModified)
+		if err != nil {
+			return false
+		}
+		return !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+{{end}}{{range .Resources}}
 func (adaptor {{name}}Adaptor) {{handlerSig .}} {
 	context := &rdl.ResourceContext{Writer: writer, Request: request, Params: params, Principal: nil}
 {{handlerBody .}}
@@ -261,14 +1937,82 @@ func (gen *serverGenerator) processTemplate(templateSource string) error {
 		"basename":    basenameFunc,
 		"comment":     commentFun,
 		"uMethod":     func(r *rdl.Resource) string { return strings.ToUpper(r.Method) },
-		"methodSig":   func(r *rdl.Resource) string { return goServerMethodSignature(gen.registry, r, gen.precise) },
+		"conditional": func() bool {
+			for _, r := range gen.schema.Resources {
+				if conditionalResource(r) {
+					return true
+				}
+			}
+			return false
+		},
+		"isConditional": conditionalResource,
+		"isStream":      streamResource,
+		"isPatch":       func(r *rdl.Resource) bool { return goPatchTypes(gen.registry, r, false) != "" },
+		"patches": func() bool {
+			for _, r := range gen.schema.Resources {
+				if goPatchTypes(gen.registry, r, false) != "" {
+					return true
+				}
+			}
+			return false
+		},
+		"patchTargetSig": func(r *rdl.Resource) string { return goPatchTargetSignature(gen.registry, r, gen.precise) },
+		"streams": func() bool {
+			for _, r := range gen.schema.Resources {
+				if streamResource(r) {
+					return true
+				}
+			}
+			return false
+		},
+		"formBodies": func() bool { return goFormBodies(gen.registry, gen.schema) },
+		"idempotent": func() bool {
+			for _, r := range gen.schema.Resources {
+				if idempotentResource(r) {
+					return true
+				}
+			}
+			return false
+		},
+		"idempotencyTTL": func(r *rdl.Resource) (string, error) { return goIdempotencyTTL(gen.registry, r, gen.precise) },
+		"selectable": func() bool {
+			for _, r := range gen.schema.Resources {
+				if len(goFieldPaths(gen.registry, r, false)) > 0 {
+					return true
+				}
+			}
+			return false
+		},
+		"fieldPaths": func(r *rdl.Resource) string {
+			paths := goFieldPaths(gen.registry, r, true)
+			if len(paths) == 0 {
+				return ""
+			}
+			n, _ := goMethodName(gen.registry, r, gen.precise)
+			s := fmt.Sprintf("\n\t%q: {", capitalize(n))
+			for _, path := range paths {
+				s += fmt.Sprintf("\n\t\t%q: true,", path)
+			}
+			return s + "\n\t},"
+		},
+		"limitDefault": func(r *rdl.Resource) (string, error) { return goLimitDefault(gen.registry, r, gen.precise) },
+		"cMethodName": func(r *rdl.Resource) string {
+			n, _ := goMethodName(gen.registry, r, gen.precise)
+			return capitalize(n)
+		},
+		"methodSig": func(r *rdl.Resource) string {
+			if gen.requestResponse {
+				return goReqRepServerMethodSignature(gen.registry, r, gen.precise)
+			}
+			return goServerMethodSignature(gen.registry, r, gen.precise)
+		},
 		"handlerName": func(r *rdl.Resource) string {
 			n, _ := goMethodName(gen.registry, r, gen.precise)
 			return uncapitalize(n) + "Handler"
 		},
 		"handlerSig": func(r *rdl.Resource) string { return goHandlerSignature(gen.registry, r, gen.precise) },
 		"handlerBody": func(r *rdl.Resource) string {
-			return goHandlerBody(gen.registry, gen.name, r, gen.precise, gen.prefixEnums)
+			return goHandlerBody(gen.registry, gen.name, r, gen.precise, gen.prefixEnums, gen.requestResponse)
 		},
 		"client":     func() string { return gen.name + "Client" },
 		"server":     func() string { return gen.name + "Server" },
@@ -281,6 +2025,362 @@ func (gen *serverGenerator) processTemplate(templateSource string) error {
 	return t.Execute(gen.writer, gen.schema)
 }
 
+// conditionalResource returns true if the resource is a GET annotated with x_conditional, in which
+// case the server computes ETags, honors conditional request headers, and also serves HEAD.
+func conditionalResource(r *rdl.Resource) bool {
+	if r.Method != "GET" || r.Expected == "NO_CONTENT" {
+		return false
+	}
+	_, ok := r.Annotations["x_conditional"]
+	return ok
+}
+
+// idempotentResource returns true if the resource is a POST or PATCH annotated with
+// x_idempotency_key, whose handler replays the responses to retried requests.
+func idempotentResource(r *rdl.Resource) bool {
+	if r.Method != "POST" && r.Method != "PATCH" {
+		return false
+	}
+	_, ok := r.Annotations["x_idempotency_key"]
+	return ok
+}
+
+// goLimitDefault returns the entry of the generated default limits map for the resource, from
+// its x_rate_limit ("10", "10/s", "600/m", or "3600/h"), x_rate_burst, x_max_in_flight,
+// x_max_body ("65536", "64k", or "1m") and x_timeout ("500ms", "10s") annotations. The burst
+// defaults to the whole number of requests that the rate allows per second, and at least 1.
+// Resources without any of these annotations are not limited by default. A malformed annotation
+// is an error.
+func goLimitDefault(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) (string, error) {
+	bad := func(annotation, spec, expected string) error {
+		return fmt.Errorf("bad %s '%s' on %s %s, expected %s", annotation, spec, r.Method, r.Path, expected)
+	}
+	rate := 0.0
+	burst := 0
+	inFlight := 0
+	if spec, ok := r.Annotations["x_rate_limit"]; ok {
+		per := 1.0
+		count := spec
+		if i := strings.Index(spec, "/"); i >= 0 {
+			switch strings.TrimSpace(spec[i+1:]) {
+			case "s":
+			case "m":
+				per = 60
+			case "h":
+				per = 3600
+			default:
+				return "", bad("x_rate_limit", spec, "a number of requests per \"s\", \"m\" or \"h\", such as \"600/m\"")
+			}
+			count = spec[:i]
+		}
+		var err error
+		if rate, err = strconv.ParseFloat(strings.TrimSpace(count), 64); err != nil || rate <= 0 {
+			return "", bad("x_rate_limit", spec, "a positive number of requests, such as \"10/s\"")
+		}
+		rate = rate / per
+	}
+	if spec, ok := r.Annotations["x_rate_burst"]; ok {
+		var err error
+		if burst, err = strconv.Atoi(spec); err != nil || burst <= 0 {
+			return "", bad("x_rate_burst", spec, "a positive number of requests")
+		}
+	}
+	if spec, ok := r.Annotations["x_max_in_flight"]; ok {
+		var err error
+		if inFlight, err = strconv.Atoi(spec); err != nil || inFlight <= 0 {
+			return "", bad("x_max_in_flight", spec, "a positive number of requests")
+		}
+	}
+	var maxBody int64
+	if spec, ok := r.Annotations["x_max_body"]; ok {
+		size := spec
+		scale := int64(1)
+		if size != "" {
+			switch strings.ToLower(size[len(size)-1:]) {
+			case "k":
+				scale = 1 << 10
+			case "m":
+				scale = 1 << 20
+			}
+		}
+		if scale > 1 {
+			size = size[:len(size)-1]
+		}
+		var err error
+		if maxBody, err = strconv.ParseInt(size, 10, 64); err != nil || maxBody <= 0 {
+			return "", bad("x_max_body", spec, "a positive size in bytes, such as \"65536\", \"64k\" or \"1m\"")
+		}
+		maxBody *= scale
+	}
+	var timeout time.Duration
+	if spec, ok := r.Annotations["x_timeout"]; ok {
+		var err error
+		if timeout, err = time.ParseDuration(spec); err != nil || timeout < time.Millisecond {
+			return "", bad("x_timeout", spec, "a duration of at least 1ms, such as \"500ms\"")
+		}
+		if streamResource(r) {
+			return "", fmt.Errorf("x_timeout is not supported on the x_stream resource %s %s", r.Method, r.Path)
+		}
+	}
+	var fields []string
+	if rate != 0 {
+		if burst == 0 {
+			burst = int(rate)
+			if burst < 1 {
+				burst = 1
+			}
+		}
+		fields = append(fields, fmt.Sprintf("Rate: %g, Burst: %d", rate, burst))
+	}
+	if inFlight != 0 {
+		fields = append(fields, fmt.Sprintf("MaxInFlight: %d", inFlight))
+	}
+	if maxBody != 0 {
+		fields = append(fields, fmt.Sprintf("MaxBody: %d", maxBody))
+	}
+	if timeout != 0 {
+		fields = append(fields, fmt.Sprintf("Timeout: %d * time.Millisecond", timeout.Milliseconds()))
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	methName, _ := goMethodName(reg, r, precise)
+	return fmt.Sprintf("\n\t%q: {%s},", capitalize(methName), strings.Join(fields, ", ")), nil
+}
+
+// goIdempotencyTTL returns the entry of the generated idempotency map for a POST or PATCH resource
+// annotated with x_idempotency_key. The value of the annotation is the time that responses are
+// kept for replay, i.e. "1h". The default is 24 hours.
+func goIdempotencyTTL(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) (string, error) {
+	spec, ok := r.Annotations["x_idempotency_key"]
+	if !ok {
+		return "", nil
+	}
+	if !idempotentResource(r) {
+		log.Printf("RDL error: x_idempotency_key is only supported on POST and PATCH resources, not %s %s\n", r.Method, r.Path)
+		return "", nil
+	}
+	ttl := 24 * time.Hour
+	if spec != "" {
+		var err error
+		if ttl, err = time.ParseDuration(spec); err != nil || ttl <= 0 {
+			return "", fmt.Errorf("bad x_idempotency_key '%s' on %s %s, expected a duration such as \"1h\"", spec, r.Method, r.Path)
+		}
+	}
+	methName, _ := goMethodName(reg, r, precise)
+	return fmt.Sprintf("\n\t%q: %d * time.Second,", capitalize(methName), int64(ttl.Seconds())), nil
+}
+
+// goFieldPaths returns the dotted paths of the fields of the response of a GET resource annotated
+// with x_fields, which the client can select with the fields query parameter. Fields of nested
+// structs, and of structs in arrays, are included. The resource type must be a struct or an array
+// of structs.
+func goFieldPaths(reg rdl.TypeRegistry, r *rdl.Resource, warn bool) []string {
+	if _, ok := r.Annotations["x_fields"]; !ok {
+		return nil
+	}
+	t := reg.FindType(r.Type)
+	if t != nil && t.Variant == rdl.TypeVariantArrayTypeDef {
+		t = reg.FindType(t.ArrayTypeDef.Items)
+	}
+	valid := r.Method == "GET" && !streamResource(r) && t != nil && reg.BaseType(t) == rdl.BaseTypeStruct
+	for _, in := range r.Inputs {
+		if in.QueryParam == "fields" {
+			valid = false
+		}
+	}
+	if !valid {
+		if warn {
+			log.Printf("RDL error: x_fields is only supported on GET resources of struct or array of struct types, without a fields parameter, not %s %s\n", r.Method, r.Path)
+		}
+		return nil
+	}
+	return addFieldPaths(reg, nil, t, "", map[rdl.TypeName]bool{})
+}
+
+func addFieldPaths(reg rdl.TypeRegistry, dst []string, t *rdl.Type, prefix string, seen map[rdl.TypeName]bool) []string {
+	tName, _, _ := rdl.TypeInfo(t)
+	if seen[tName] {
+		return dst
+	}
+	seen[tName] = true
+	for _, f := range flattenedFields(reg, t) {
+		path := prefix + string(f.Name)
+		dst = append(dst, path)
+		ft := reg.FindType(f.Type)
+		if ft != nil && reg.BaseType(ft) == rdl.BaseTypeArray {
+			items := f.Items
+			if ft.Variant == rdl.TypeVariantArrayTypeDef {
+				items = ft.ArrayTypeDef.Items
+			}
+			ft = reg.FindType(items)
+		}
+		if ft != nil && reg.BaseType(ft) == rdl.BaseTypeStruct {
+			dst = addFieldPaths(reg, dst, ft, path+".", seen)
+		}
+	}
+	delete(seen, tName)
+	return dst
+}
+
+// streamResource returns true if the resource is a GET annotated with x_stream="sse", in which case
+// the implementation sends values of the resource type to the client as Server-Sent Events.
+func streamResource(r *rdl.Resource) bool {
+	return r.Method == "GET" && r.Annotations["x_stream"] == "sse"
+}
+
+// goStreamSend returns the type of the send function passed to the implementation of a stream.
+func goStreamSend(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
+	return "send func(" + gomodel.GoType(reg, r.Type, false, "", "", precise, true) + ") error"
+}
+
+// goPatchTypes returns the patch content types accepted by a PATCH resource annotated with
+// x_patch, separated by spaces. The value of the annotation is "merge" for JSON merge patches
+// (RFC 7396), "json" for JSON Patch (RFC 6902), or empty for both.
+func goPatchTypes(reg rdl.TypeRegistry, r *rdl.Resource, warn bool) string {
+	spec, ok := r.Annotations["x_patch"]
+	if !ok {
+		return ""
+	}
+	var body *rdl.ResourceInput
+	for _, in := range r.Inputs {
+		if !in.PathParam && in.QueryParam == "" && in.Header == "" && in.Context == "" {
+			body = in
+		}
+	}
+	if r.Method != "PATCH" || body == nil || reg.BaseTypeName(body.Type) != "Struct" || goBodyContentType(reg, r, false) != "" {
+		if warn {
+			log.Printf("RDL error: x_patch is only supported on PATCH resources with a JSON struct body, not %s %s\n", r.Method, r.Path)
+		}
+		return ""
+	}
+	switch spec {
+	case "merge":
+		return "application/merge-patch+json"
+	case "json":
+		return "application/json-patch+json"
+	case "":
+		return "application/merge-patch+json application/json-patch+json"
+	}
+	if warn {
+		log.Printf("RDL error: bad x_patch '%s', expected \"merge\" or \"json\"\n", spec)
+	}
+	return ""
+}
+
+// goPatchTargetSignature returns the signature of the handler method that returns the current value
+// of the resource that a patch is applied to.
+func goPatchTargetSignature(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
+	methName, _ := goMethodName(reg, r, precise)
+	params := []string{"context *rdl.ResourceContext"}
+	gtype := ""
+	for _, in := range r.Inputs {
+		if in.PathParam {
+			params = append(params, goName(string(in.Name))+" "+gomodel.GoType2(reg, in.Type, false, "", "", precise, true, ""))
+		} else if in.QueryParam == "" && in.Header == "" && in.Context == "" {
+			gtype = gomodel.GoType(reg, in.Type, false, "", "", precise, true)
+		}
+	}
+	return capitalize(methName) + "Target(" + strings.Join(params, ", ") + ") (" + gtype + ", error)"
+}
+
+// goBodyContentType returns the content type of the request body of a resource, declared with the
+// x_content_type annotation or a consumes statement. Bytes bodies can be sent as
+// "application/octet-stream", and struct bodies as "multipart/form-data", with their Bytes fields
+// as file uploads, or as "application/x-www-form-urlencoded". The default is JSON, returned as "".
+func goBodyContentType(reg rdl.TypeRegistry, r *rdl.Resource, warn bool) string {
+	contentType := r.Annotations["x_content_type"]
+	if contentType == "" && len(r.Consumes) > 0 {
+		contentType = r.Consumes[0]
+	}
+	if contentType == "" || contentType == "application/json" {
+		return ""
+	}
+	var body *rdl.ResourceInput
+	for _, in := range r.Inputs {
+		if !in.PathParam && in.QueryParam == "" && in.Header == "" && in.Context == "" {
+			body = in
+		}
+	}
+	if body == nil {
+		return ""
+	}
+	bt := reg.BaseTypeName(body.Type)
+	switch contentType {
+	case "application/octet-stream":
+		if bt == "Bytes" {
+			return contentType
+		}
+	case "multipart/form-data", "application/x-www-form-urlencoded":
+		if bt == "Struct" {
+			return contentType
+		}
+	}
+	if warn {
+		log.Printf("RDL error: %s body of %s %s cannot be sent as %s, using JSON\n", body.Type, r.Method, r.Path, contentType)
+	}
+	return ""
+}
+
+// goFormFieldKinds returns a map literal of the kinds of the fields of a struct body sent as a form:
+// "string", "number", "bool", or "bytes", which are sent as file uploads, or one of them prefixed
+// with "[]" for an array field, which is sent as a value for each item. Other fields cannot be sent
+// in a form, and are an error.
+func goFormFieldKinds(reg rdl.TypeRegistry, typeName rdl.TypeRef) (string, error) {
+	var kinds []string
+	for _, f := range flattenedFields(reg, reg.FindType(typeName)) {
+		kind := goFormKind(reg, f.Type)
+		if kind == "" && reg.BaseTypeName(f.Type) == "Array" {
+			items := f.Items
+			if t := reg.FindType(f.Type); items == "" && t != nil && t.ArrayTypeDef != nil {
+				items = t.ArrayTypeDef.Items
+			}
+			if kind = goFormKind(reg, items); kind != "" {
+				kind = "[]" + kind
+			}
+		}
+		if kind == "" {
+			return "", fmt.Errorf("field '%s' of %s cannot be sent in a form", f.Name, typeName)
+		}
+		kinds = append(kinds, fmt.Sprintf("%q: %q", f.Name, kind))
+	}
+	return "map[string]string{" + strings.Join(kinds, ", ") + "}", nil
+}
+
+// goFormKind returns the kind of a form value of the given type, or "" if it has none.
+func goFormKind(reg rdl.TypeRegistry, t rdl.TypeRef) string {
+	switch reg.BaseTypeName(t) {
+	case "Int8", "Int16", "Int32", "Int64", "Float32", "Float64":
+		return "number"
+	case "Bool":
+		return "bool"
+	case "Bytes":
+		return "bytes"
+	case "String", "Symbol", "Enum", "Timestamp", "UUID":
+		return "string"
+	}
+	return ""
+}
+
+// goCheckFormBodies returns an error if the body of a resource sent as a form has a field that
+// cannot be sent in a form, i.e. a struct or a map.
+func goCheckFormBodies(reg rdl.TypeRegistry, schema *rdl.Schema) error {
+	for _, r := range schema.Resources {
+		switch goBodyContentType(reg, r, false) {
+		case "multipart/form-data", "application/x-www-form-urlencoded":
+			for _, in := range r.Inputs {
+				if in.QueryParam != "" || in.PathParam || in.Header != "" {
+					continue
+				}
+				if _, err := goFormFieldKinds(reg, in.Type); err != nil {
+					return fmt.Errorf("%s %s: %v", r.Method, r.Path, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func resourcePath(r *rdl.Resource) string {
 	path := r.Path
 	i := strings.Index(path, "?")
@@ -305,18 +2405,73 @@ const authenticateTemplate = `	if !adaptor.authenticate(context) {
 		return
 	}
 `
-const authorizeTemplate = `	if !adaptor.authorize(context, %q, %s) {
+const idempotentTemplate = `	writer, done := adaptor.idempotent(%q, context, writer, request)
+	if done == nil {
+		return
+	}
+	defer done()
+	context.Writer = writer
+`
+const limitTemplate = `	release, retryAfter := adaptor.limiter.acquire(%q, adaptor.limitKey(context))
+	if release == nil {
+		writer.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		rdl.JSONResponse(writer, 429, rdl.ResourceError{Code: http.StatusTooManyRequests, Message: "Too Many Requests"})
+		return
+	}
+	defer release()
+`
+const authorizeTemplate = `	if !adaptor.authorize(context, %q, %s, %s) {
 		rdl.JSONResponse(writer, 403, rdl.ResourceError{Code: http.StatusForbidden, Message: "Forbidden"})
 		return
 	}
 `
 
-func goHandlerBody(reg rdl.TypeRegistry, name string, r *rdl.Resource, precise bool, prefixEnums bool) string {
-	s := ""
+// goAuthString returns the Go expression for a resource or domain string of an authorize statement,
+// with its {var} references replaced by the values of the corresponding arguments.
+func goAuthString(spec string) string {
+	return goAuthStringWith(spec, func(name string) string { return "string(arg" + capitalize(name) + ")" })
+}
+
+// goAuthStringWith is goAuthString with the expression of the value of each {var} returned by arg.
+func goAuthStringWith(spec string, arg func(name string) string) string {
+	i := strings.Index(spec, "{")
+	for i >= 0 {
+		j := strings.Index(spec[i:], "}")
+		if j < 0 {
+			break
+		}
+		j += i
+		val := arg(spec[i+1 : j])
+		spec = spec[0:i] + "\" + " + val + " + \"" + spec[j+1:]
+		i = strings.Index(spec, "{")
+	}
+	spec = "\"" + spec
+	if strings.HasSuffix(spec, "+ \"") {
+		spec = spec[0 : len(spec)-3]
+	} else {
+		spec = spec + "\""
+	}
+	if strings.HasPrefix(spec, "\"\" + ") {
+		spec = spec[5:]
+	}
+	return spec
+}
+
+func goHandlerBody(reg rdl.TypeRegistry, name string, r *rdl.Resource, precise bool, prefixEnums bool, requestResponse bool) string {
+	methName, _ := goMethodName(reg, r, precise)
+	resourceName := capitalize(methName)
+	//requests over the limits are rejected before their body is read or they are authorized
+	s := fmt.Sprintf(limitTemplate, resourceName)
 	var fargs []string
+	var pathArgs []string
 	bodyName := ""
+	patchTypes := goPatchTypes(reg, r, true)
+	selectable := len(goFieldPaths(reg, r, true)) > 0
 	for _, in := range r.Inputs {
 		name := "arg" + capitalize(string(in.Name))
+		if in.PathParam {
+			pathArgs = append(pathArgs, name)
+		}
 		if in.QueryParam != "" {
 			qname := in.QueryParam
 			if in.Optional || in.Default != nil {
@@ -375,68 +2530,154 @@ func goHandlerBody(reg rdl.TypeRegistry, name string, r *rdl.Resource, precise b
 		} else {
 			bodyName = name
 			pgtype := gomodel.GoType(reg, in.Type, false, "", "", precise, true)
-			s += "\tvar " + bodyName + " " + pgtype + "\n"
-			s += "\toserr := json.NewDecoder(request.Body).Decode(&" + bodyName + ")\n"
-			s += "\tif oserr != nil {\n"
+			body := "adaptor.limiter.body(" + fmt.Sprintf("%q", resourceName) + ", request)"
+			contentType := goBodyContentType(reg, r, true)
+			switch contentType {
+			case "application/octet-stream":
+				s += "\t" + bodyName + " := " + body + "\n"
+				fargs = append(fargs, bodyName)
+				continue
+			case "multipart/form-data", "application/x-www-form-urlencoded":
+				s += "\tvar " + bodyName + " " + pgtype + "\n"
+				kinds, _ := goFormFieldKinds(reg, in.Type)
+				s += "\toserr := decodeFormBody(request, " + body + ", &" + bodyName + ", " + kinds + ")\n"
+			default:
+				if patchTypes != "" {
+					s += "\tvar " + bodyName + " " + pgtype + "\n"
+					s += "\tpatch, oserr := readPatch(request, " + body + ", &" + bodyName + ", " + fmt.Sprintf("%q", patchTypes) + ")\n"
+					s += "\tif e, ok := oserr.(*rdl.ResourceError); ok {\n"
+					s += "\t\trdl.JSONResponse(writer, e.Code, e)\n"
+					s += "\t\treturn\n"
+					s += "\t}\n"
+					break
+				}
+				s += "\tvar " + bodyName + " " + pgtype + "\n"
+				s += "\toserr := json.NewDecoder(" + body + ").Decode(&" + bodyName + ")\n"
+			}
+			s += "\tif errors.Is(oserr, errBodyTooLarge) {\n"
+			s += "\t\trdl.JSONResponse(writer, http.StatusRequestEntityTooLarge, rdl.ResourceError{Code: http.StatusRequestEntityTooLarge, Message: \"Request Entity Too Large\"})\n"
+			s += "\t\treturn\n"
+			s += "\t} else if oserr != nil {\n"
 			s += "\t\trdl.JSONResponse(writer, http.StatusBadRequest, rdl.ResourceError{Code: http.StatusBadRequest, Message: \"Bad request: \" + oserr.Error()})\n"
 			s += "\t\treturn\n"
 			s += "\t}\n"
 			fargs = append(fargs, bodyName)
 		}
 	}
+	if selectable {
+		s += "\tfields, err := selectFields(request, \"" + resourceName + "\")\n"
+		s += "\tif err != nil {\n"
+		s += "\t\trdl.JSONResponse(writer, 400, err)\n"
+		s += "\t\treturn\n"
+		s += "\t}\n"
+	}
 	if r.Auth != nil {
 		if r.Auth.Authenticate {
 			s += authenticateTemplate
 		} else if r.Auth.Action != "" && r.Auth.Resource != "" {
-			resource := r.Auth.Resource
-			i := strings.Index(resource, "{")
-			for i >= 0 {
-				j := strings.Index(resource[i:], "}")
-				if j < 0 {
-					break
-				}
-				j += i
-				val := "string(arg" + capitalize(resource[i+1:j]) + ")"
-				resource = resource[0:i] + "\" + " + val + " + \"" + resource[j+1:]
-				i = strings.Index(resource, "{")
-			}
-			resource = "\"" + resource
-			if strings.HasSuffix(resource, "+ \"") {
-				resource = resource[0 : len(resource)-3]
-			} else {
-				resource = resource + "\""
-			}
-			if strings.HasPrefix(resource, "\"\" + ") {
-				resource = resource[5:]
-			}
-			s += fmt.Sprintf(authorizeTemplate, r.Auth.Action, resource)
+			s += fmt.Sprintf(authorizeTemplate, r.Auth.Action, goAuthString(r.Auth.Resource), goAuthString(r.Auth.Domain))
 		} else {
 			log.Println("*** Badly formed auth spec in resource input:", r)
 		}
 	}
-	methName, _ := goMethodName(reg, r, precise)
+	if idempotentResource(r) {
+		s += fmt.Sprintf(idempotentTemplate, resourceName)
+	}
+	if patchTypes != "" {
+		//the patch is applied once the request is authorized, to the current value from the implementation
+		s += "\tif patch != nil {\n"
+		s += "\t\ttarget, err := adaptor.impl." + resourceName + "Target(context" + strings.Join(append([]string{""}, pathArgs...), ", ") + ")\n"
+		s += "\t\tif err == nil {\n"
+		s += "\t\t\terr = patch.apply(target, &" + bodyName + ")\n"
+		s += "\t\t}\n"
+		s += "\t\tif err != nil {\n"
+		s += "\t\t\tswitch e := err.(type) {\n"
+		s += "\t\t\tcase *rdl.ResourceError:\n"
+		s += "\t\t\t\trdl.JSONResponse(writer, e.Code, err)\n"
+		s += "\t\t\tdefault:\n"
+		s += "\t\t\t\trdl.JSONResponse(writer, 500, &rdl.ResourceError{Code: 500, Message: e.Error()})\n"
+		s += "\t\t\t}\n"
+		s += "\t\t\treturn\n"
+		s += "\t\t}\n"
+		s += "\t}\n"
+	}
 	sargs := ""
 	if len(fargs) > 0 {
 		sargs = ", " + strings.Join(fargs, ", ")
 	}
+	if _, ok := r.Annotations["x_stream"]; ok && !streamResource(r) {
+		log.Printf("RDL error: x_stream is only supported as \"sse\" on GET resources, not on %s %s\n", r.Method, r.Path)
+	}
+	if streamResource(r) {
+		//the response is committed once the stream starts, so later errors are sent as events
+		if requestResponse {
+			m := (&reqRepClientGenerator{}).convertResource(reg, r, precise)
+			var fields []string
+			for i, in := range r.Inputs {
+				fields = append(fields, capitalize(goName(string(in.Name)))+": "+fargs[i])
+			}
+			s += "\treq := &" + m.RequestName + "{" + strings.Join(fields, ", ") + "}\n"
+			sargs = ", req"
+		}
+		gtype := gomodel.GoType(reg, r.Type, false, "", "", precise, true)
+		s += "\tstream := newEventStream(writer, request)\n"
+		s += "\terr := adaptor.impl." + resourceName + "(context" + sargs + ", func(event " + gtype + ") error {\n"
+		s += "\t\treturn stream.send(\"\", event)\n"
+		s += "\t})\n"
+		s += "\tif err != nil && request.Context().Err() == nil {\n"
+		s += "\t\tspanError(request, err)\n"
+		s += "\t\tswitch e := err.(type) {\n"
+		s += "\t\tcase *rdl.ResourceError:\n"
+		s += "\t\t\tstream.send(\"error\", e)\n"
+		s += "\t\tdefault:\n"
+		s += "\t\t\tstream.send(\"error\", &rdl.ResourceError{Code: 500, Message: e.Error()})\n"
+		s += "\t\t}\n"
+		s += "\t}\n"
+		return s
+	}
 	outHeaders := ""
 	for _, v := range r.Outputs {
 		outHeaders += ", " + string(v.Name)
 	}
+	//in request/response mode, the inputs and outputs are fields of the shared structs instead
+	data := "data"
+	outValue := func(v *rdl.ResourceOutput) string { return string(v.Name) }
 	noContent := r.Expected == "NO_CONTENT" && len(r.Alternatives) == 0
-	if noContent {
+	if requestResponse {
+		m := (&reqRepClientGenerator{}).convertResource(reg, r, precise)
+		var fields []string
+		for i, in := range r.Inputs {
+			fields = append(fields, capitalize(goName(string(in.Name)))+": "+fargs[i])
+		}
+		s += "\treq := &" + m.RequestName + "{" + strings.Join(fields, ", ") + "}\n"
+		s += "\tresp, err := adaptor.impl." + m.Name + "(context, req)\n"
+		s += "\tif err == nil && resp == nil {\n"
+		s += "\t\tresp = &" + m.ResponseName + "{}\n"
+		s += "\t}\n"
+		data = "resp.Body"
+		outValue = func(v *rdl.ResourceOutput) string { return "resp." + capitalize(goName(string(v.Name))) }
+	} else if noContent {
 		s += "\terr" + outHeaders + " := adaptor.impl." + capitalize(methName) + "(context" + sargs + ")\n"
 	} else {
 		s += "\tdata" + outHeaders + ", err := adaptor.impl." + capitalize(methName) + "(context" + sargs + ")\n"
 	}
+	if selectable {
+		data = "fields.prune(" + data + ")"
+	}
 	s += "\tif err != nil {\n"
+	s += "\t\tspanError(request, err)\n"
 	s += "\t\tswitch e := err.(type) {\n"
 	s += "\t\tcase *rdl.ResourceError:\n"
 	//special case the 304 response, which MUST have an etag in it
 	for _, v := range r.Outputs {
 		if strings.ToLower(v.Header) == "etag" {
-			s += "\t\t\tif e.Code == 304 && " + string(v.Name) + " != \"\" {\n"
-			s += "\t\t\t\twriter.Header().Set(\"" + v.Header + "\", " + string(v.Name) + ")\n"
+			if requestResponse {
+				s += "\t\t\tif e.Code == 304 && resp != nil && " + outValue(v) + " != \"\" {\n"
+				s += "\t\t\t\twriter.Header().Set(\"" + v.Header + "\", string(" + outValue(v) + "))\n"
+			} else {
+				s += "\t\t\tif e.Code == 304 && " + string(v.Name) + " != \"\" {\n"
+				s += "\t\t\t\twriter.Header().Set(\"" + v.Header + "\", " + string(v.Name) + ")\n"
+			}
 			s += "\t\t\t}\n"
 			break
 		}
@@ -444,12 +2685,23 @@ func goHandlerBody(reg rdl.TypeRegistry, name string, r *rdl.Resource, precise b
 
 	s += "\t\t\trdl.JSONResponse(writer, e.Code, err)\n"
 	s += "\t\tdefault:\n"
+	if goBodyContentType(reg, r, false) == "application/octet-stream" {
+		//the implementation reads the streamed body, so it is the one to see that it is too large
+		s += "\t\t\tif errors.Is(err, errBodyTooLarge) {\n"
+		s += "\t\t\t\trdl.JSONResponse(writer, http.StatusRequestEntityTooLarge, rdl.ResourceError{Code: http.StatusRequestEntityTooLarge, Message: \"Request Entity Too Large\"})\n"
+		s += "\t\t\t\treturn\n"
+		s += "\t\t\t}\n"
+	}
 	s += "\t\t\trdl.JSONResponse(writer, 500, &rdl.ResourceError{Code: 500, Message: e.Error()})\n"
 	s += "\t\t}\n"
 	s += "\t} else {\n"
 	for _, v := range r.Outputs {
 		vname := string(v.Name)
-		if v.Optional {
+		if requestResponse {
+			s += "\t\tif " + outValue(v) + " != \"\" {\n"
+			s += "\t\t\twriter.Header().Set(\"" + v.Header + "\", string(" + outValue(v) + "))\n"
+			s += "\t\t}\n"
+		} else if v.Optional {
 			s += "\t\tif " + vname + " != nil {\n"
 			s += "\t\t\twriter.Header().Set(\"" + v.Header + "\", " + vname + ")\n"
 			s += "\t\t}\n"
@@ -461,7 +2713,11 @@ func goHandlerBody(reg rdl.TypeRegistry, name string, r *rdl.Resource, precise b
 		s += fmt.Sprintf("\t\twriter.WriteHeader(204)\n")
 	} else {
 		//fixme: handle alternative responses. How deos the handler pass them back?
-		s += fmt.Sprintf("\t\trdl.JSONResponse(writer, %s, data)\n", rdl.StatusCode(r.Expected))
+		if conditionalResource(r) {
+			s += fmt.Sprintf("\t\tconditionalResponse(writer, request, %s, %s)\n", rdl.StatusCode(r.Expected), data)
+		} else {
+			s += fmt.Sprintf("\t\trdl.JSONResponse(writer, %s, %s)\n", rdl.StatusCode(r.Expected), data)
+		}
 	}
 	s += "\t}\n"
 	return s
@@ -618,6 +2874,11 @@ func goHandlerSignature(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) str
 func goServerMethodSignature(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
 	noContent := r.Expected == "NO_CONTENT" && r.Alternatives == nil
 	returnSpec := "error"
+	if streamResource(r) {
+		methName, params := goMethodName(reg, r, precise)
+		params = append(params, goStreamSend(reg, r, precise))
+		return capitalize(methName) + "(context *rdl.ResourceContext, " + strings.Join(params, ", ") + ") error"
+	}
 	if !noContent {
 		gtype := gomodel.GoType(reg, r.Type, false, "", "", precise, true)
 		outHeaders := ""
@@ -634,6 +2895,16 @@ func goServerMethodSignature(reg rdl.TypeRegistry, r *rdl.Resource, precise bool
 	return capitalize(methName) + "(context *rdl.ResourceContext" + sparams + ") " + returnSpec
 }
 
+// goReqRepServerMethodSignature returns the handler method signature in request/response mode,
+// which takes and returns the structs shared with the request/response client.
+func goReqRepServerMethodSignature(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) string {
+	m := (&reqRepClientGenerator{}).convertResource(reg, r, precise)
+	if streamResource(r) {
+		return m.Name + "(context *rdl.ResourceContext, req *" + m.RequestName + ", " + goStreamSend(reg, r, precise) + ") error"
+	}
+	return m.Name + "(context *rdl.ResourceContext, req *" + m.RequestName + ") (*" + m.ResponseName + ", error)"
+}
+
 func goMethodName(reg rdl.TypeRegistry, r *rdl.Resource, precise bool) (string, []string) {
 	return goMethodName2(reg, r, precise, "")
 }
@@ -648,6 +2919,10 @@ func goMethodName2(reg rdl.TypeRegistry, r *rdl.Resource, precise bool, packageN
 		k := v.Name
 		if v.QueryParam == "" && !v.PathParam && v.Header == "" {
 			bodyType = string(v.Type)
+			if goBodyContentType(reg, r, false) == "application/octet-stream" {
+				params = append(params, goName(string(k))+" io.Reader")
+				continue
+			}
 		}
 		optional := false
 		if v.Optional {
@@ -0,0 +1,50 @@
+// Copyright 2015 Yahoo Inc.
+// Licensed under the terms of the Apache version 2.0 license. See LICENSE file for terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ardielle/ardielle-go/rdl"
+)
+
+// TestGoLimitDefault checks the default limits generated for the limit annotations of a resource,
+// and that malformed annotations are errors.
+func TestGoLimitDefault(t *testing.T) {
+	tests := []struct {
+		annotations map[rdl.ExtendedAnnotation]string
+		expected    string
+	}{
+		{nil, ""},
+		{map[rdl.ExtendedAnnotation]string{"x_rate_limit": "600/m"}, `"GetThing": {Rate: 10, Burst: 10},`},
+		{map[rdl.ExtendedAnnotation]string{"x_rate_limit": "60/h", "x_rate_burst": "3"}, `"GetThing": {Rate: 0.016666666666666666, Burst: 3},`},
+		{map[rdl.ExtendedAnnotation]string{"x_max_in_flight": "2", "x_max_body": "64k"}, `"GetThing": {MaxInFlight: 2, MaxBody: 65536},`},
+		{map[rdl.ExtendedAnnotation]string{"x_timeout": "1s"}, `"GetThing": {Timeout: 1000 * time.Millisecond},`},
+		{map[rdl.ExtendedAnnotation]string{"x_rate_limit": "10/d"}, "error"},
+		{map[rdl.ExtendedAnnotation]string{"x_rate_limit": "ten"}, "error"},
+		{map[rdl.ExtendedAnnotation]string{"x_rate_limit": "10x/s"}, "error"},
+		{map[rdl.ExtendedAnnotation]string{"x_rate_burst": "-1"}, "error"},
+		{map[rdl.ExtendedAnnotation]string{"x_max_in_flight": "1.5"}, "error"},
+		{map[rdl.ExtendedAnnotation]string{"x_max_body": "64g"}, "error"},
+		{map[rdl.ExtendedAnnotation]string{"x_max_body": ""}, "error"},
+		{map[rdl.ExtendedAnnotation]string{"x_timeout": "10"}, "error"},
+		{map[rdl.ExtendedAnnotation]string{"x_timeout": "10us"}, "error"},
+		{map[rdl.ExtendedAnnotation]string{"x_timeout": "1s", "x_stream": "sse"}, "error"},
+	}
+	schema := &rdl.Schema{Name: "basic"}
+	reg := rdl.NewTypeRegistry(schema)
+	for _, test := range tests {
+		r := &rdl.Resource{Type: "Thing", Method: "GET", Path: "/things", Name: "GetThing", Annotations: test.annotations}
+		entry, err := goLimitDefault(reg, r, false)
+		if test.expected == "error" {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %q", test.annotations, entry)
+			}
+		} else if err != nil {
+			t.Errorf("%v: %v", test.annotations, err)
+		} else if expected := test.expected; expected != "" && entry != "\n\t"+expected || expected == "" && entry != "" {
+			t.Errorf("%v: expected %q, got %q", test.annotations, expected, entry)
+		}
+	}
+}
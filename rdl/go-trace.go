@@ -0,0 +1,82 @@
+// Copyright 2015 Yahoo Inc.
+// Licensed under the terms of the Apache version 2.0 license. See LICENSE file for terms.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const traceContextTemplate = `{{header}}
+
+package {{package}}
+
+import (
+	"context"
+)
+
+// traceContextKey is the context key for the W3C trace context of a call.
+type traceContextKey struct{}
+
+// traceContext is the W3C trace context of a call: its traceparent and tracestate headers.
+type traceContext struct {
+	parent string
+	state  string
+}
+
+//
+// ContextWithTraceParent returns a copy of ctx that carries the W3C trace context, i.e. the
+// traceparent and tracestate headers of an incoming request, which the generated clients of this
+// package then propagate. The generated server of this package puts the trace context of each
+// request in its context; to pass it on to the client of another package, copy it with
+// TraceParentFromContext and the ContextWithTraceParent of that package.
+//
+func ContextWithTraceParent(ctx context.Context, traceParent string, traceState string) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, traceContext{parent: traceParent, state: traceState})
+}
+
+// TraceParentFromContext returns the traceparent and tracestate of the W3C trace context that ctx
+// carries, or empty strings if it has none.
+func TraceParentFromContext(ctx context.Context) (traceParent string, traceState string) {
+	tc, _ := ctx.Value(traceContextKey{}).(traceContext)
+	return tc.parent, tc.state
+}
+`
+
+// GenerateGoTraceContext generates the W3C trace context helpers shared by the Go server and
+// client, in <name>_trace.go next to them.
+func GenerateGoTraceContext(opts *generateOptions) error {
+	schema := opts.schema
+	outdir := opts.dirName
+	if outdir == "" {
+		outdir = "."
+	} else if strings.HasSuffix(outdir, ".go") {
+		outdir = filepath.Dir(outdir)
+	}
+	path := filepath.Join(outdir, strings.ToLower(string(schema.Name))+"_trace.go")
+	out, file, _, err := outputWriter(path, "", ".go")
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		defer func() {
+			file.Close()
+			err := goFmt(path)
+			if err != nil {
+				fmt.Println("Warning: could not format go code:", err)
+			}
+		}()
+	}
+	funcMap := template.FuncMap{
+		"header":  func() string { return generationHeader(opts.banner) },
+		"package": func() string { return generationPackage(schema, opts.ns) },
+	}
+	t := template.Must(template.New("TRACE_CONTEXT_TEMPLATE").Funcs(funcMap).Parse(traceContextTemplate))
+	if err := t.Execute(out, schema); err != nil {
+		return err
+	}
+	return out.Flush()
+}
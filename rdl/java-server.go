@@ -706,6 +706,22 @@ func (gen *javaServerGenerator) resourcePath(r *rdl.Resource) string {
 	return path
 }
 
+// javaAuthString returns the Java expression for a resource or domain string of an authorize
+// statement, with its {var} references replaced by the values of the corresponding parameters.
+func javaAuthString(spec string) string {
+	i := strings.Index(spec, "{")
+	for i >= 0 {
+		j := strings.Index(spec[i:], "}")
+		if j < 0 {
+			break
+		}
+		j += i
+		spec = spec[0:i] + "\" + " + spec[i+1:j] + " + \"" + spec[j+1:]
+		i = strings.Index(spec, "{")
+	}
+	return "\"" + spec + "\""
+}
+
 func (gen *javaServerGenerator) handlerBody(r *rdl.Resource) string {
 	async := r.Async != nil && *r.Async
 	resultWrapper := len(r.Outputs) > 0 || async
@@ -721,20 +737,11 @@ func (gen *javaServerGenerator) handlerBody(r *rdl.Resource) string {
 		if r.Auth.Authenticate {
 			s += "            context.authenticate();\n"
 		} else if r.Auth.Action != "" && r.Auth.Resource != "" {
-			resource := r.Auth.Resource
-			i := strings.Index(resource, "{")
-			for i >= 0 {
-				j := strings.Index(resource[i:], "}")
-				if j < 0 {
-					break
-				}
-				j += i
-				resource = resource[0:i] + "\" + " + resource[i+1:j] + " + \"" + resource[j+1:]
-				i = strings.Index(resource, "{")
+			domain := "null"
+			if r.Auth.Domain != "" {
+				domain = javaAuthString(r.Auth.Domain)
 			}
-			resource = "\"" + resource + "\""
-			s += fmt.Sprintf("            context.authorize(%q, %s, null);\n", r.Auth.Action, resource)
-			//what about the domain variant?
+			s += fmt.Sprintf("            context.authorize(%q, %s, %s);\n", r.Auth.Action, javaAuthString(r.Auth.Resource), domain)
 		} else {
 			log.Println("*** Badly formed auth spec in resource input:", r)
 		}
@@ -49,6 +49,18 @@ Generator Options:
   -l package      Generate code that imports this package as 'rdl' for base type impl (instead of standard rdl library)
   -u type         Generate the specified union type to JSON serialize as an untagged union. Default is a tagged.
   -x key=value    Set options for external generator, e.g. -x e=true -xfoo=bar will send -e true --foo bar to external generator.
+  --with-request-response  Generate Go servers and clients whose calls take and return per-resource Request/Response structs,
+                   shared between them in <name>_reqrep.go (default is false)
+  --with-client-fake  Also generate the {Name}API interface of the Go client, and a Fake{Name} implementation of it
+                   for tests, in <name>_api.go (default is false)
+  --with-in-process-client  Also generate a {Name}InProcessClient, which makes the calls of the Go client directly on the
+                   {Name}Handler of the Go server, generated into the same package, in <name>_inprocess.go (default is false)
+  --with-client-cache  Also generate the CacheMiddleware of the Go client, with an in-memory LRUResponseCache, and its
+                   WithCache option (default is false)
+  --with-client-cassette  Also generate the CassetteTransport of the Go client, which records its calls to a file and
+                   replays them in tests (default is false)
+  --with-authenticators  Also generate the JWT and HTTP Basic authenticators of the Go server, in <name>_authn.go, which
+                   depends on golang.org/x/crypto (default is false)
 
 Generators (accepted arguments to the generate command):
   json               Generate the JSON representation of the schema
@@ -143,6 +155,11 @@ func main() {
 		basePath := cmd.StringOpt("b", "", "Specify the base path of the URL for java server and client generators (default = schema name, snake-cased)")
 		externalOptions := cmd.StringsOpt("x", []string{}, "Set options for external generator, e.g. -x e=true -xfoo=bar will send -e true --foo bar to external generator")
 		requestResponse := cmd.BoolOpt("with-request-response", false, "Enable request/response objects")
+		clientFake := cmd.BoolOpt("with-client-fake", false, "Generate the Go client interface and a fake for tests")
+		inProcessClient := cmd.BoolOpt("with-in-process-client", false, "Generate a Go client that calls the server handler directly")
+		clientCache := cmd.BoolOpt("with-client-cache", false, "Generate the response cache of the Go client")
+		clientCassette := cmd.BoolOpt("with-client-cassette", false, "Generate the record and replay transport of the Go client")
+		authenticators := cmd.BoolOpt("with-authenticators", false, "Generate the JWT and HTTP Basic authenticators of the Go server")
 		generator := cmd.StringArg("GENERATOR", "", "the generator to use")
 		schemaFile := cmd.StringArg("FILE", "", "the rdl file defining the schema")
 		cmd.Action = func() {
@@ -156,6 +173,11 @@ func main() {
 				dirName:         *outfile,
 				librdl:          *librdl,
 				requestResponse: *requestResponse,
+				clientFake:      *clientFake,
+				inProcessClient: *inProcessClient,
+				clientCache:     *clientCache,
+				clientCassette:  *clientCassette,
+				authenticators:  *authenticators,
 				prefixEnums:     *prefixEnums,
 				preciseTypes:    *preciseTypes,
 				ns:              *ns,
@@ -260,6 +282,11 @@ type generateOptions struct {
 	schemaFile      string
 	banner          string
 	requestResponse bool
+	clientFake      bool
+	inProcessClient bool
+	clientCache     bool
+	clientCassette  bool
+	authenticators  bool
 	dirName         string
 	librdl          string
 	prefixEnums     bool
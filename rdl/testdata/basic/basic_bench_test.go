@@ -0,0 +1,65 @@
+// Copyright 2015 Yahoo Inc.
+// Licensed under the terms of the Apache version 2.0 license. See LICENSE file for terms.
+
+package basic
+
+// The benchmarks of the generated Go client, i.e. with
+//
+//	go test -bench . -benchmem ./rdl/testdata/basic
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newBenchmarkServer starts a server that answers all calls with 200 and a Thing.
+func newBenchmarkServer(b *testing.B) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"name":"bench","count":1}`)
+	}))
+	b.Cleanup(server.Close)
+	return server
+}
+
+func benchmarkGetThing(b *testing.B, client BasicClient) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		thing, err := client.GetThing("bench")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if thing.Name != "bench" {
+			b.Fatal(thing)
+		}
+	}
+}
+
+// BenchmarkSharedClient calls the server with a client created by NewClient, which makes all its
+// calls with one http.Client.
+func BenchmarkSharedClient(b *testing.B) {
+	benchmarkGetThing(b, NewClient(newBenchmarkServer(b).URL, nil))
+}
+
+// BenchmarkPerCallClient calls the server with a client that was not created by a constructor,
+// which builds an http.Client for each call, as all the clients did before NewClient shared one.
+func BenchmarkPerCallClient(b *testing.B) {
+	benchmarkGetThing(b, BasicClient{URL: newBenchmarkServer(b).URL})
+}
+
+// BenchmarkSharedClientTransport is BenchmarkSharedClient with a Transport of the client's own.
+func BenchmarkSharedClientTransport(b *testing.B) {
+	transport := &http.Transport{}
+	b.Cleanup(transport.CloseIdleConnections)
+	benchmarkGetThing(b, NewClient(newBenchmarkServer(b).URL, transport))
+}
+
+// BenchmarkPerCallClientTransport is BenchmarkPerCallClient with a Transport of the client's own.
+func BenchmarkPerCallClientTransport(b *testing.B) {
+	transport := &http.Transport{}
+	b.Cleanup(transport.CloseIdleConnections)
+	benchmarkGetThing(b, BasicClient{URL: newBenchmarkServer(b).URL, Transport: transport})
+}
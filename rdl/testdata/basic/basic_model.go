@@ -0,0 +1,105 @@
+//
+// Code generated by rdl (test) DO NOT EDIT.
+//
+
+package basic
+
+import (
+	"encoding/json"
+	"fmt"
+	rdl "github.com/ardielle/ardielle-go/rdl"
+)
+
+var _ = rdl.Version
+var _ = json.Marshal
+var _ = fmt.Printf
+
+// Thing -
+type Thing struct {
+	Name  string `json:"name"`
+	Count *int32 `json:"count,omitempty" rdl:"optional"`
+}
+
+// NewThing - creates an initialized Thing instance, returns a pointer to it
+func NewThing(init ...*Thing) *Thing {
+	var o *Thing
+	if len(init) == 1 {
+		o = init[0]
+	} else {
+		o = new(Thing)
+	}
+	return o
+}
+
+type rawThing Thing
+
+// UnmarshalJSON is defined for proper JSON decoding of a Thing
+func (self *Thing) UnmarshalJSON(b []byte) error {
+	var m rawThing
+	err := json.Unmarshal(b, &m)
+	if err == nil {
+		o := Thing(m)
+		*self = o
+		err = self.Validate()
+	}
+	return err
+}
+
+// Validate - checks for missing required fields, etc
+func (self *Thing) Validate() error {
+	if self.Name == "" {
+		return fmt.Errorf("Thing.name is missing but is a required field")
+	} else {
+		val := rdl.Validate(BasicSchema(), "String", self.Name)
+		if !val.Valid {
+			return fmt.Errorf("Thing.name does not contain a valid String (%v)", val.Error)
+		}
+	}
+	return nil
+}
+
+// Things -
+type Things struct {
+	Things []*Thing `json:"things"`
+}
+
+// NewThings - creates an initialized Things instance, returns a pointer to it
+func NewThings(init ...*Things) *Things {
+	var o *Things
+	if len(init) == 1 {
+		o = init[0]
+	} else {
+		o = new(Things)
+	}
+	return o.Init()
+}
+
+// Init - sets up the instance according to its default field values, if any
+func (self *Things) Init() *Things {
+	if self.Things == nil {
+		self.Things = make([]*Thing, 0)
+	}
+	return self
+}
+
+type rawThings Things
+
+// UnmarshalJSON is defined for proper JSON decoding of a Things
+func (self *Things) UnmarshalJSON(b []byte) error {
+	var m rawThings
+	err := json.Unmarshal(b, &m)
+	if err == nil {
+		o := Things(m)
+		*self = *((&o).Init())
+		err = self.Validate()
+	}
+	return err
+}
+
+// Validate - checks for missing required fields, etc
+func (self *Things) Validate() error {
+	if self.Things == nil {
+		return fmt.Errorf("Things: Missing required field: things")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+//
+// Code generated by rdl (test) DO NOT EDIT.
+//
+
+package basic
+
+import (
+	"log"
+
+	rdl "github.com/ardielle/ardielle-go/rdl"
+)
+
+var schema *rdl.Schema
+
+func init() {
+	sb := rdl.NewSchemaBuilder("basic")
+	sb.Version(1)
+	sb.Comment("A schema without annotations, for the tests of the Go generators.")
+
+	tThing := rdl.NewStructTypeBuilder("Struct", "Thing")
+	tThing.Field("name", "String", false, nil, "")
+	tThing.Field("count", "Int32", true, nil, "")
+	sb.AddType(tThing.Build())
+
+	tThings := rdl.NewStructTypeBuilder("Struct", "Things")
+	tThings.ArrayField("things", "Thing", false, "")
+	sb.AddType(tThings.Build())
+
+	mGetThing := rdl.NewResourceBuilder("Thing", "GET", "/things/{name}")
+	mGetThing.Input("name", "String", true, "", "", false, nil, "")
+	sb.AddResource(mGetThing.Build())
+
+	mGetThings := rdl.NewResourceBuilder("Things", "GET", "/things")
+	mGetThings.Input("limit", "Int32", false, "limit", "", true, nil, "")
+	sb.AddResource(mGetThings.Build())
+
+	mPutThing := rdl.NewResourceBuilder("Thing", "PUT", "/things/{name}")
+	mPutThing.Input("name", "String", true, "", "", false, nil, "")
+	mPutThing.Input("thing", "Thing", false, "", "", false, nil, "")
+	mPutThing.Auth("write", "thing.{name}", false, "")
+	sb.AddResource(mPutThing.Build())
+
+	mDeleteThing := rdl.NewResourceBuilder("Thing", "DELETE", "/things/{name}")
+	mDeleteThing.Input("name", "String", true, "", "", false, nil, "")
+	mDeleteThing.Auth("", "", true, "")
+	mDeleteThing.Expected("NO_CONTENT")
+	sb.AddResource(mDeleteThing.Build())
+
+	var err error
+	schema, err = sb.BuildParanoid()
+	if err != nil {
+		log.Fatalf("rdl: schema build failed: %s", err)
+	}
+}
+
+func BasicSchema() *rdl.Schema {
+	return schema
+}